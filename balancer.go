@@ -1,35 +1,43 @@
 package balancer
 
 import (
-	"sort"
+	"context"
+	"fmt"
+	"math"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
-type bySecondsBehindMaster Servers
+// defaultCheckInterval is Config.CheckInterval's default, in seconds
+const defaultCheckInterval = 3
 
-func (a bySecondsBehindMaster) Len() int      { return len(a) }
-func (a bySecondsBehindMaster) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
-func (a bySecondsBehindMaster) Less(i, j int) bool {
-	if a[i].health.secondsBehindMaster == nil && a[j].health.secondsBehindMaster == nil {
+type byReplicationLag Servers
+
+func (a byReplicationLag) Len() int      { return len(a) }
+func (a byReplicationLag) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byReplicationLag) Less(i, j int) bool {
+	if a[i].health.replicationLag == nil && a[j].health.replicationLag == nil {
 		return false
 	}
-	if a[i].health.secondsBehindMaster == nil && a[j].health.secondsBehindMaster != nil {
+	if a[i].health.replicationLag == nil && a[j].health.replicationLag != nil {
 		return false
 	}
-	if a[i].health.secondsBehindMaster != nil && a[j].health.secondsBehindMaster == nil {
+	if a[i].health.replicationLag != nil && a[j].health.replicationLag == nil {
 		return true
 	}
 
-	return *a[i].health.secondsBehindMaster < *a[j].health.secondsBehindMaster
+	return *a[i].health.replicationLag < *a[j].health.replicationLag
 }
 
 type byConnections Servers
 
 func (a byConnections) Len() int      { return len(a) }
 func (a byConnections) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+
+// Less compares connections normalized by each server's weight
 func (a byConnections) Less(i, j int) bool {
+	weightI, weightJ := a[i].GetWeight(), a[j].GetWeight()
 
 	if a[i].health.runningConnections == nil && a[j].health.runningConnections != nil {
 		return false
@@ -39,7 +47,7 @@ func (a byConnections) Less(i, j int) bool {
 	}
 
 	if a[i].health.runningConnections == nil || a[j].health.runningConnections == nil ||
-		*a[i].health.runningConnections == *a[j].health.runningConnections {
+		*a[i].health.runningConnections*weightJ == *a[j].health.runningConnections*weightI {
 
 		if a[i].health.openConnections == nil && a[j].health.openConnections == nil {
 			return false
@@ -51,22 +59,31 @@ func (a byConnections) Less(i, j int) bool {
 			return true
 		}
 
-		return *a[i].health.openConnections < *a[j].health.openConnections
+		return *a[i].health.openConnections*weightJ < *a[j].health.openConnections*weightI
 
 	}
 
-	return *a[i].health.runningConnections < *a[j].health.runningConnections
+	return *a[i].health.runningConnections*weightJ < *a[j].health.runningConnections*weightI
 
 }
 
 // Balancer MySQL load balancer
 type Balancer struct {
 	config      *Config
-	servers     Servers
+	servers     Servers      // replaced, never modified, by AddServer, RemoveServer and UpdateServer
+	serversLock sync.RWMutex // protects servers
 	logger      Logger
 	traceOn     bool
 	checkerLock sync.Mutex    // prevent Balancer.Close and Balancer.check from running at the same time
 	stopChecker chan struct{} // signal for health check goroutine
+
+	membershipLock sync.Mutex // serializes AddServer, RemoveServer and UpdateServer
+
+	checkInterval        int64         // seconds, see SetCheckInterval
+	checkIntervalChanged chan struct{} // signal for health check goroutine to reset its ticker
+
+	stopHeartbeat context.CancelFunc // stops the heartbeat writer goroutine
+	stopDiscovery context.CancelFunc // stops the discovery goroutines
 }
 
 func (b *Balancer) Close() {
@@ -78,23 +95,36 @@ func (b *Balancer) Close() {
 		b.stopChecker = nil
 	}
 
-	for _, s := range b.servers {
+	if b.stopHeartbeat != nil {
+		b.stopHeartbeat()
+		b.stopHeartbeat = nil
+	}
+
+	if b.stopDiscovery != nil {
+		b.stopDiscovery()
+		b.stopDiscovery = nil
+	}
+
+	for _, s := range b.GetServers() {
 		if s != nil {
 			s.Close()
 		}
 	}
 }
 
-// GetServers ...
+// GetServers returns a snapshot of the balancer's servers
 func (b *Balancer) GetServers() Servers {
+	b.serversLock.RLock()
+	defer b.serversLock.RUnlock()
 	return b.servers
 }
 
-// serversUP returns a slice of UP servers
+// serversUP returns a slice of UP replicas
 func (b *Balancer) serversUP() Servers {
-	serversUP := make(Servers, 0, len(b.servers))
-	for _, server := range b.servers {
-		if server.health.IsUP() {
+	servers := b.GetServers()
+	serversUP := make(Servers, 0, len(servers))
+	for _, server := range servers {
+		if !server.IsPrimary() && server.health.IsUP() {
 			serversUP = append(serversUP, server)
 		}
 	}
@@ -110,17 +140,59 @@ func (b *Balancer) check() {
 		return
 	}
 
-	b.servers.eachASYNC(func(index int, server *Server) {
+	b.GetServers().eachASYNC(func(index int, server *Server) {
 		server.CheckHealth(b.traceOn, b.logger)
 	})
 }
 
-func (b *Balancer) waitCheck() {
-	wait := b.config.StartupWait
+// checker checks the servers' health every check interval until stop is
+// closed
+func (b *Balancer) checker(stop chan struct{}) {
+	ticker := time.NewTicker(b.getCheckInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-b.checkIntervalChanged:
+			ticker.Reset(b.getCheckInterval())
+		case <-ticker.C:
+			b.check()
+		}
+	}
+}
+
+func (b *Balancer) getCheckInterval() time.Duration {
+	return time.Duration(atomic.LoadInt64(&b.checkInterval)) * time.Second
+}
+
+// SetCheckInterval changes the interval, in seconds, between health checks of
+// a running balancer. HealthCheckTimeout and CircuitBreakerCooldown keep the
+// values they defaulted to.
+func (b *Balancer) SetCheckInterval(seconds int64) error {
+	if seconds < 1 {
+		return fmt.Errorf("balancer: invalid check interval %d", seconds)
+	}
+
+	if atomic.SwapInt64(&b.checkInterval, seconds) != seconds {
+		select {
+		case b.checkIntervalChanged <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (b *Balancer) startupWait() time.Duration {
 	// default to 5s
-	if wait <= 0 {
-		wait = time.Second * 5
+	if b.config.StartupWait <= 0 {
+		return time.Second * 5
 	}
+	return b.config.StartupWait
+}
+
+func (b *Balancer) waitCheck() {
+	wait := b.startupWait()
 
 	signal := make(chan struct{}, 0)
 	var expired atomic.Value
@@ -130,12 +202,13 @@ func (b *Balancer) waitCheck() {
 		signal <- struct{}{}
 	})
 
+	servers := b.GetServers()
 	go func() {
-		for i := range b.servers {
+		for i := range servers {
 			if expired.Load() != nil {
 				return
 			}
-			b.servers[i].CheckHealth(b.traceOn, b.logger)
+			servers[i].CheckHealth(b.traceOn, b.logger)
 		}
 		if t.Stop() {
 			signal <- struct{}{}
@@ -145,82 +218,226 @@ func (b *Balancer) waitCheck() {
 	<-signal
 }
 
+// strategy returns the configured selection strategy or the default one
+func (b *Balancer) strategy() Strategy {
+	if b.config.Strategy != nil {
+		return b.config.Strategy
+	}
+	return NewDefaultStrategy(b.config)
+}
+
 // PickServer returns the best server at a given point in time
 func (b *Balancer) PickServer() *Server {
-	candidates := b.serversUP()
-	switch len(candidates) {
-	case 0:
+	candidates := b.eligibleServers()
+	if len(candidates) == 0 {
+		if b.config.LagFallback == LagFallbackPrimary {
+			return b.PickWriter()
+		}
 		return nil
-	case 1:
-		return candidates[0]
 	}
 
-	if b.config.ReplicationMode == ReplicationModeMultiSourceWriteSet {
-		candidates = candidates.filterByWriteSetStatus()
-	} else {
-		candidates = candidates.filterBySecondsBehindMaster()
+	return b.strategy().Pick(candidates)
+}
+
+// PickWriter returns the first UP primary server, if any. With
+// ReplicationModeGroupReplication it falls back to the group's primary.
+func (b *Balancer) PickWriter() *Server {
+	for _, server := range b.GetServers() {
+		if server.IsPrimary() && server.health.IsUP() {
+			return server
+		}
 	}
 
-	switch len(candidates) {
-	case 0:
-		candidates = b.serversUP()
-	case 1:
-		return candidates[0]
+	if b.config.ReplicationMode == ReplicationModeGroupReplication {
+		for _, server := range b.serversUP().filterByGroupMemberState(GroupMemberStateOnline) {
+			if server.health.IsGroupPrimary() {
+				return server
+			}
+		}
 	}
 
-	if len(candidates) == 0 {
+	return nil
+}
+
+// eligibleServers returns the UP replicas allowed to serve reads
+func (b *Balancer) eligibleServers() Servers {
+	candidates := b.serversUP().filterByCircuitBreaker()
+	switch b.config.ReplicationMode {
+	case ReplicationModeGroupReplication:
+		candidates = candidates.filterByGroupMemberState(GroupMemberStateOnline)
+	case ReplicationModeSingleSource:
+		if b.config.ExcludeSQLThreadStopped {
+			candidates = candidates.filterBySQLRunning()
+		}
+	}
+	return b.filterByLocality(b.filterByMaxReplicationLag(candidates))
+}
+
+// filterByLocality returns the candidates in Config.LocalZone, or else in
+// Config.LocalRegion, or else every candidate. See Config.ZoneSpillRatio.
+func (b *Balancer) filterByLocality(candidates Servers) Servers {
+	if b.config.LocalZone != "" {
+		if local := candidates.filterByZone(b.config.LocalZone); !b.shouldSpill(local, candidates) {
+			return local
+		}
+	}
+	if b.config.LocalRegion != "" {
+		if local := candidates.filterByRegion(b.config.LocalRegion); !b.shouldSpill(local, candidates) {
+			return local
+		}
+	}
+	return candidates
+}
+
+// shouldSpill tells whether to look beyond the local candidates: when there is
+// none or they are Config.ZoneSpillRatio times busier than the others
+func (b *Balancer) shouldSpill(local, candidates Servers) bool {
+	if len(local) == 0 {
+		return true
+	}
+	if b.config.ZoneSpillRatio <= 0 || len(local) == len(candidates) {
+		return false
+	}
+
+	remote := candidates
+	for _, server := range local {
+		remote = remote.without(server)
+	}
+	// idle remote servers count as lightly loaded, so a few queries do not
+	// spill the traffic
+	return local.load() > b.config.ZoneSpillRatio*math.Max(remote.load(), 1)
+}
+
+// filterByMaxReplicationLag removes the replicas beyond
+// Config.MaxSecondsBehindMaster, applying Config.LagFallback when none is left
+func (b *Balancer) filterByMaxReplicationLag(candidates Servers) Servers {
+	if b.config.MaxSecondsBehindMaster <= 0 || b.config.ReplicationMode != ReplicationModeSingleSource {
+		return candidates
+	}
+
+	filtered := candidates.filterByMaxReplicationLag(time.Duration(b.config.MaxSecondsBehindMaster) * time.Second)
+	if len(filtered) > 0 {
+		return filtered
+	}
+
+	switch b.config.LagFallback {
+	case LagFallbackLeastLagged:
+		if leastLagged := candidates.filterByReplicationLag(0); len(leastLagged) > 0 {
+			return leastLagged
+		}
+		return candidates
+	default:
 		return nil
 	}
+}
+
+// SetWeight changes the weight of the named server, see ServerSettings.Weight
+func (b *Balancer) SetWeight(name string, weight int) error {
+	if weight < 1 {
+		return fmt.Errorf("balancer: invalid weight %d for server %q", weight, name)
+	}
 
-	sort.Sort(byConnections(candidates))
-	return candidates[0]
+	server := b.GetServers().byName(name)
+	if server == nil {
+		return fmt.Errorf("balancer: server %q not found", name)
+	}
+
+	server.setWeight(weight)
+	return nil
 }
 
-// New creates a new instance of Balancer
+// newServer creates a server from its settings and the balancer's config
+func (b *Balancer) newServer(serverSettings ServerSettings) *Server {
+	heartbeatTable := serverSettings.HeartbeatTable
+	if heartbeatTable == "" {
+		heartbeatTable = b.config.HeartbeatTable
+	}
+
+	server := &Server{
+		name:           serverSettings.Name,
+		serverSettings: serverSettings,
+		health: &ServerHealth{
+			lastUpdate: time.Now(),
+			rise:       b.config.HealthCheckRise,
+			fall:       b.config.HealthCheckFall,
+		},
+		replicationMode:    b.config.ReplicationMode,
+		heartbeatTable:     heartbeatTable,
+		healthCheckTimeout: b.config.HealthCheckTimeout,
+		weight:             int64(serverSettings.Weight),
+		breaker: circuitBreaker{
+			threshold: b.config.CircuitBreakerThreshold,
+			cooldown:  b.config.CircuitBreakerCooldown,
+		},
+	}
+	server.recheck = func() {
+		server.CheckHealth(b.traceOn, b.logger)
+	}
+
+	return server
+}
+
+// New creates a new instance of Balancer. It neither validates nor modifies
+// config, see NewWithError.
 func New(config *Config) *Balancer {
+	return newBalancer(*config)
+}
+
+// NewWithError validates config and creates a new instance of Balancer. When
+// config is invalid, it returns ConfigErrors listing every problem. It does
+// not modify config.
+func NewWithError(config *Config) (*Balancer, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return newBalancer(*config), nil
+}
+
+// newBalancer creates a balancer from its own copy of the config, applying the
+// defaults to it
+func newBalancer(config Config) *Balancer {
 	// Minimum check interval
 	if config.CheckInterval == 0 {
-		config.CheckInterval = 3
+		config.CheckInterval = defaultCheckInterval
 	}
 
-	servers := make(Servers, len(config.ServersSettings))
-	for i, serverSettings := range config.ServersSettings {
-		servers[i] = &Server{
-			name:           serverSettings.Name,
-			serverSettings: serverSettings,
-			health: &ServerHealth{
-				lastUpdate: time.Now(),
-			},
-			replicationMode: config.ReplicationMode,
-		}
+	if config.HealthCheckTimeout <= 0 {
+		config.HealthCheckTimeout = time.Duration(config.CheckInterval) * time.Second
+	}
+
+	if config.CircuitBreakerCooldown <= 0 {
+		config.CircuitBreakerCooldown = time.Duration(config.CheckInterval) * time.Second
 	}
 
 	balancer := &Balancer{
-		config:  config,
-		servers: servers,
-		logger:  config.Logger,
-		traceOn: config.TraceOn,
+		config:               &config,
+		logger:               config.Logger,
+		traceOn:              config.TraceOn,
+		checkInterval:        config.CheckInterval,
+		checkIntervalChanged: make(chan struct{}, 1),
+	}
+
+	balancer.servers = make(Servers, len(config.ServersSettings))
+	for i, serverSettings := range config.ServersSettings {
+		balancer.servers[i] = balancer.newServer(serverSettings)
+	}
+
+	if discoverers := balancer.discoverers(); len(discoverers) > 0 {
+		balancer.startDiscovery(discoverers)
 	}
 
 	balancer.waitCheck()
+	if config.HeartbeatInterval > 0 && config.HeartbeatTable != "" {
+		balancer.startHeartbeat()
+	}
+
 	if config.StartCheck {
 		if balancer.stopChecker != nil {
 			close(balancer.stopChecker)
 		}
 		balancer.stopChecker = make(chan struct{})
 
-		go func() {
-			ticker := time.NewTicker(time.Duration(config.CheckInterval) * time.Second)
-			defer ticker.Stop()
-			for {
-				select {
-				case <-balancer.stopChecker:
-					return
-				case <-ticker.C:
-					balancer.check()
-				}
-			}
-		}()
+		go balancer.checker(balancer.stopChecker)
 	}
 
 	return balancer
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"sort"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -17,18 +18,20 @@ var (
 
 func init() {
 	var intNilHelper *int
-	zeroHelper := 0
+	var durationNilHelper *time.Duration
+	zeroLagHelper := time.Duration(0)
 	oneHelper := 1
 	wsrepState := WriteSetStateSync
 	wsrepStateNoSync := 2
 	thousandHelper := 1000
+	thousandSecondsLagHelper := 1000 * time.Second
 
 	ServerDownDueToMySQLConnection = &Server{
 		name:   "ServerDownDueToMySQLConnection",
 		health: &ServerHealth{},
 	}
 	ServerDownDueToMySQLConnection.health.setDown(
-		errors.New("__MYSQL_CONNECTION_ERROR__"), false, false, intNilHelper, intNilHelper, intNilHelper, intNilHelper,
+		errors.New("__MYSQL_CONNECTION_ERROR__"), false, false, durationNilHelper, intNilHelper, intNilHelper, intNilHelper,
 	)
 
 	ServerUPWithMySQLSlaveStatusError = &Server{
@@ -36,7 +39,7 @@ func init() {
 		health: &ServerHealth{},
 	}
 	ServerUPWithMySQLSlaveStatusError.health.setUP(
-		errors.New("__MYSQL_SLAVE_STATUS_ERROR__"), false, false, intNilHelper, intNilHelper, intNilHelper, intNilHelper,
+		errors.New("__MYSQL_SLAVE_STATUS_ERROR__"), false, false, durationNilHelper, intNilHelper, intNilHelper, intNilHelper,
 	)
 
 	ServerUPWithMySQLThreadStatusError = &Server{
@@ -44,44 +47,44 @@ func init() {
 		health: &ServerHealth{},
 	}
 	ServerUPWithMySQLThreadStatusError.health.setUP(
-		errors.New("__MYSQL_THREADS_STATUS_ERROR__"), false, false, intNilHelper, intNilHelper, intNilHelper, intNilHelper,
+		errors.New("__MYSQL_THREADS_STATUS_ERROR__"), false, false, durationNilHelper, intNilHelper, intNilHelper, intNilHelper,
 	)
 
 	ServerUP = &Server{
 		name:   "ServerUP",
 		health: &ServerHealth{},
 	}
-	ServerUP.health.setUP(nil, true, true, &zeroHelper, &oneHelper, &oneHelper, &wsrepState)
+	ServerUP.health.setUP(nil, true, true, &zeroLagHelper, &oneHelper, &oneHelper, &wsrepState)
 
 	ServerUPWithDelay = &Server{
 		name:   "ServerUPWithDelay",
 		health: &ServerHealth{},
 	}
-	ServerUPWithDelay.health.setUP(nil, true, true, &thousandHelper, &oneHelper, &oneHelper, &wsrepState)
+	ServerUPWithDelay.health.setUP(nil, true, true, &thousandSecondsLagHelper, &oneHelper, &oneHelper, &wsrepState)
 
 	ServerUPWithNoSync = &Server{
 		name:   "ServerUPWithNoSync",
 		health: &ServerHealth{},
 	}
-	ServerUPWithNoSync.health.setUP(nil, true, true, &thousandHelper, &oneHelper, &oneHelper, &wsrepStateNoSync)
+	ServerUPWithNoSync.health.setUP(nil, true, true, &thousandSecondsLagHelper, &oneHelper, &oneHelper, &wsrepStateNoSync)
 
 	ServerUPWithHighThreadConnections = &Server{
 		name:   "ServerUPWithHighThreadConnections",
 		health: &ServerHealth{},
 	}
-	ServerUPWithHighThreadConnections.health.setUP(nil, true, true, &zeroHelper, &thousandHelper, &oneHelper, &wsrepState)
+	ServerUPWithHighThreadConnections.health.setUP(nil, true, true, &zeroLagHelper, &thousandHelper, &oneHelper, &wsrepState)
 
 	ServerUPWithDelayAndHighThreadConnections = &Server{
 		name:   "ServerUPWithDelayAndHighThreadConnections",
 		health: &ServerHealth{},
 	}
-	ServerUPWithDelayAndHighThreadConnections.health.setUP(nil, true, true, &thousandHelper, &thousandHelper, &oneHelper, &wsrepState)
+	ServerUPWithDelayAndHighThreadConnections.health.setUP(nil, true, true, &thousandSecondsLagHelper, &thousandHelper, &oneHelper, &wsrepState)
 
 	ServerUPWithHighRunningConnections = &Server{
 		name:   "ServerUPWithHighRunningConnections",
 		health: &ServerHealth{},
 	}
-	ServerUPWithHighRunningConnections.health.setUP(nil, true, true, &zeroHelper, &thousandHelper, &thousandHelper, &wsrepState)
+	ServerUPWithHighRunningConnections.health.setUP(nil, true, true, &zeroLagHelper, &thousandHelper, &thousandHelper, &wsrepState)
 }
 
 func TestBalancer(t *testing.T) {
@@ -323,6 +326,12 @@ func TestNewBalancer(t *testing.T) {
 			So(balancer, ShouldNotBeNil)
 			So(balancer.GetServers(), ShouldHaveLength, 2)
 		})
+
+		Convey("It should not modify the config", func() {
+			New(config).Close()
+			So(config.CheckInterval, ShouldEqual, 0)
+			So(config.HealthCheckTimeout, ShouldEqual, 0)
+		})
 	})
 }
 
@@ -387,6 +396,88 @@ func TestSortByConnection(t *testing.T) {
 	})
 }
 
+func TestSortByConnectionWithWeights(t *testing.T) {
+	Convey("When servers with different weights are given", t, func() {
+		servers := Servers{
+			{name: "small", weight: 1, health: &ServerHealth{
+				openConnections:    &[]int{10}[0],
+				runningConnections: &[]int{4}[0],
+			}},
+			{name: "large", weight: 8, health: &ServerHealth{
+				openConnections:    &[]int{40}[0],
+				runningConnections: &[]int{16}[0],
+			}},
+			{name: "medium", weight: 4, health: &ServerHealth{
+				openConnections:    &[]int{8}[0],
+				runningConnections: &[]int{16}[0],
+			}},
+		}
+
+		Convey("It should compare connections per unit of weight", func() {
+			sort.Sort(byConnections(servers))
+			So(servers[0].name, ShouldEqual, "large")
+			So(servers[1].name, ShouldEqual, "medium")
+			So(servers[2].name, ShouldEqual, "small")
+		})
+	})
+}
+
+func TestSetWeight(t *testing.T) {
+	Convey("Given a balancer", t, func() {
+		server := &Server{name: "replica", weight: 2, health: &ServerHealth{}}
+		balancer := &Balancer{config: &Config{}, servers: Servers{server}}
+
+		Convey("It should change the weight of a server", func() {
+			So(server.GetWeight(), ShouldEqual, 2)
+			So(balancer.SetWeight("replica", 5), ShouldBeNil)
+			So(server.GetWeight(), ShouldEqual, 5)
+		})
+
+		Convey("It should reject invalid weights and unknown servers", func() {
+			So(balancer.SetWeight("replica", 0), ShouldNotBeNil)
+			So(balancer.SetWeight("unknown", 1), ShouldNotBeNil)
+			So(server.GetWeight(), ShouldEqual, 2)
+		})
+
+		Convey("Servers default to weight 1", func() {
+			So((&Server{}).GetWeight(), ShouldEqual, 1)
+		})
+	})
+}
+
+func TestSetCheckInterval(t *testing.T) {
+	Convey("Given a running balancer", t, func() {
+		server := &Server{name: "replica", health: &ServerHealth{}}
+		stop := make(chan struct{})
+		balancer := &Balancer{
+			config:               &Config{},
+			servers:              Servers{server},
+			stopChecker:          stop,
+			checkInterval:        3600,
+			checkIntervalChanged: make(chan struct{}, 1),
+		}
+		go balancer.checker(stop)
+		defer close(stop)
+
+		Convey("It should reset the checker's ticker", func() {
+			So(balancer.SetCheckInterval(1), ShouldBeNil)
+			So(balancer.getCheckInterval(), ShouldEqual, time.Second)
+
+			// the replica cannot connect, so its first check fails
+			deadline := time.Now().Add(3 * time.Second)
+			for server.health.GetConsecutiveFailures() == 0 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			So(server.health.GetConsecutiveFailures(), ShouldBeGreaterThan, 0)
+		})
+
+		Convey("It should reject invalid intervals", func() {
+			So(balancer.SetCheckInterval(0), ShouldNotBeNil)
+			So(balancer.getCheckInterval(), ShouldEqual, time.Hour)
+		})
+	})
+}
+
 func TestFilterByWriteSetStatus(t *testing.T) {
 	Convey("When a list of servers are given", t, func() {
 		servers := Servers{
@@ -439,25 +530,25 @@ func TestFilterByWriteSetStatus(t *testing.T) {
 	})
 }
 
-func TestSortBySecondsBehindMaster(t *testing.T) {
+func TestSortByReplicationLag(t *testing.T) {
 	Convey("When a list of servers are given", t, func() {
 		servers := Servers{
 			{name: "server_2", health: &ServerHealth{
-				secondsBehindMaster: &[]int{1}[0],
+				replicationLag: &[]time.Duration{time.Second}[0],
 			}},
 			{name: "server_1", health: &ServerHealth{
-				secondsBehindMaster: nil,
+				replicationLag: nil,
 			}},
 			{name: "server_3", health: &ServerHealth{
-				secondsBehindMaster: &[]int{1}[0],
+				replicationLag: &[]time.Duration{time.Second}[0],
 			}},
 			{name: "server_4", health: &ServerHealth{
-				secondsBehindMaster: &[]int{0}[0],
+				replicationLag: &[]time.Duration{0}[0],
 			}},
 		}
 
 		Convey("It should sort correctly", func() {
-			sort.Sort(bySecondsBehindMaster(servers))
+			sort.Sort(byReplicationLag(servers))
 			So(servers, ShouldHaveLength, 4)
 			So(servers[0].name, ShouldEqual, "server_4")
 			So(servers[1].name, ShouldEqual, "server_2")
@@ -466,3 +557,261 @@ func TestSortBySecondsBehindMaster(t *testing.T) {
 		})
 	})
 }
+
+func TestPickServerWithStrategy(t *testing.T) {
+	Convey("Given a balancer with a custom strategy", t, func() {
+		var given Servers
+		config := &Config{Strategy: StrategyFunc(func(candidates Servers) *Server {
+			given = candidates
+			return candidates[len(candidates)-1]
+		})}
+		balancer := &Balancer{config: config, servers: []*Server{
+			ServerUP,
+			ServerDownDueToMySQLConnection,
+			ServerUPWithDelay,
+		}}
+
+		Convey("It only gives the UP servers to the strategy", func() {
+			So(balancer.PickServer(), ShouldPointTo, ServerUPWithDelay)
+			So(given, ShouldHaveLength, 2)
+		})
+	})
+}
+
+func TestPickServerWithMaxSecondsBehindMaster(t *testing.T) {
+	Convey("Given a balancer with a maximum replication lag", t, func() {
+		config := &Config{MaxSecondsBehindMaster: 10}
+
+		Convey("It spreads the load among every replica within the limit", func() {
+			oneSecond := time.Second
+			ServerUPLagging := &Server{name: "ServerUPLagging", health: &ServerHealth{}}
+			ServerUPLagging.health.setUP(nil, true, true, &oneSecond, &[]int{0}[0], &[]int{0}[0], nil)
+
+			balancer := &Balancer{config: config, servers: []*Server{
+				ServerUP,
+				ServerUPLagging,
+				ServerUPWithDelay,
+			}}
+			So(balancer.PickServer(), ShouldPointTo, ServerUPLagging)
+		})
+
+		Convey("It respects the lag tolerance", func() {
+			fiveSeconds := 5 * time.Second
+			ServerUPLagging := &Server{name: "ServerUPLagging", health: &ServerHealth{}}
+			ServerUPLagging.health.setUP(nil, true, true, &fiveSeconds, &[]int{0}[0], &[]int{0}[0], nil)
+
+			balancer := &Balancer{config: config, servers: []*Server{
+				ServerUP,
+				ServerUPLagging,
+			}}
+			So(balancer.PickServer(), ShouldPointTo, ServerUPLagging)
+
+			config.MaxLagTolerance = time.Second
+			So(balancer.PickServer(), ShouldPointTo, ServerUP)
+		})
+
+		Convey("It picks nothing when every replica is beyond the limit", func() {
+			balancer := &Balancer{config: config, servers: []*Server{
+				ServerUPWithDelay,
+				ServerUPWithDelayAndHighThreadConnections,
+				ServerUPWithMySQLSlaveStatusError,
+			}}
+			So(balancer.PickServer(), ShouldBeNil)
+		})
+
+		Convey("It picks the less lagged replica with the least lagged fallback", func() {
+			config.LagFallback = LagFallbackLeastLagged
+			balancer := &Balancer{config: config, servers: []*Server{
+				ServerUPWithMySQLSlaveStatusError,
+				ServerUPWithDelayAndHighThreadConnections,
+				ServerUPWithDelay,
+			}}
+			So(balancer.PickServer(), ShouldPointTo, ServerUPWithDelay)
+		})
+	})
+}
+
+func TestPickWriter(t *testing.T) {
+	Convey("Given a balancer with a primary server", t, func() {
+		primary := &Server{
+			name:           "primary",
+			health:         &ServerHealth{},
+			serverSettings: ServerSettings{Role: ServerRolePrimary},
+		}
+		primary.health.setUP(nil, false, false, nil, nil, nil, nil)
+
+		Convey("It never picks the primary for reads", func() {
+			balancer := &Balancer{config: &Config{}, servers: []*Server{primary, ServerDownDueToMySQLConnection}}
+			So(balancer.PickServer(), ShouldBeNil)
+			So(balancer.PickWriter(), ShouldPointTo, primary)
+
+			balancer = &Balancer{config: &Config{}, servers: []*Server{primary, ServerUPWithDelay}}
+			So(balancer.PickServer(), ShouldPointTo, ServerUPWithDelay)
+		})
+
+		Convey("It returns nil when the primary is down", func() {
+			primaryDown := &Server{
+				name:           "primaryDown",
+				health:         &ServerHealth{},
+				serverSettings: ServerSettings{Role: ServerRolePrimary},
+			}
+			balancer := &Balancer{config: &Config{}, servers: []*Server{primaryDown, ServerUP}}
+			So(balancer.PickWriter(), ShouldBeNil)
+		})
+
+		Convey("It falls back to the primary for reads when configured", func() {
+			config := &Config{MaxSecondsBehindMaster: 10, LagFallback: LagFallbackPrimary}
+			balancer := &Balancer{config: config, servers: []*Server{primary, ServerUPWithDelay}}
+			So(balancer.PickServer(), ShouldPointTo, primary)
+
+			balancer = &Balancer{config: config, servers: []*Server{primary, ServerUPWithDelay, ServerUP}}
+			So(balancer.PickServer(), ShouldPointTo, ServerUP)
+
+			balancer = &Balancer{config: &Config{LagFallback: LagFallbackPrimary}, servers: []*Server{
+				primary, ServerDownDueToMySQLConnection,
+			}}
+			So(balancer.PickServer(), ShouldPointTo, primary)
+		})
+	})
+}
+
+func TestPickServerWithGroupReplication(t *testing.T) {
+	Convey("Given a balancer using group replication", t, func() {
+		config := &Config{ReplicationMode: ReplicationModeGroupReplication}
+		newMember := func(name, state, role string, applierQueue, runningConnections int) *Server {
+			server := &Server{name: name, health: &ServerHealth{}}
+			server.health.setGroupReplicationStatus(state, role, &applierQueue)
+			server.health.setUP(nil, state == GroupMemberStateOnline, false, nil, &runningConnections, &runningConnections, nil)
+			return server
+		}
+
+		primary := newMember("primary", "ONLINE", "PRIMARY", 0, 10)
+		secondary := newMember("secondary", "ONLINE", "SECONDARY", 0, 5)
+		behind := newMember("behind", "ONLINE", "SECONDARY", 50, 0)
+		recovering := newMember("recovering", "RECOVERING", "SECONDARY", 0, 0)
+
+		Convey("It only picks ONLINE members with the shortest applier queue", func() {
+			balancer := &Balancer{config: config, servers: []*Server{recovering, behind, primary, secondary}}
+			So(balancer.PickServer(), ShouldPointTo, secondary)
+
+			balancer = &Balancer{config: config, servers: []*Server{recovering}}
+			So(balancer.PickServer(), ShouldBeNil)
+		})
+
+		Convey("It picks the group primary as writer", func() {
+			balancer := &Balancer{config: config, servers: []*Server{recovering, secondary, primary}}
+			So(balancer.PickWriter(), ShouldPointTo, primary)
+
+			balancer = &Balancer{config: config, servers: []*Server{recovering, secondary}}
+			So(balancer.PickWriter(), ShouldBeNil)
+		})
+	})
+}
+
+func TestPickServerExcludingSQLThreadStopped(t *testing.T) {
+	Convey("Given a balancer with a replica whose SQL thread stopped", t, func() {
+		sqlStopped := &Server{name: "sqlStopped", health: &ServerHealth{}}
+		sqlStopped.health.setReplicationThreadsStatus(false, 0, "", 1062, "Duplicate entry")
+		sqlStopped.health.setUP(nil, true, false, &[]time.Duration{0}[0], &[]int{0}[0], &[]int{0}[0], nil)
+
+		sqlRunning := &Server{name: "sqlRunning", health: &ServerHealth{}}
+		sqlRunning.health.setReplicationThreadsStatus(true, 0, "", 0, "")
+		sqlRunning.health.setUP(nil, true, false, &[]time.Duration{0}[0], &[]int{10}[0], &[]int{10}[0], nil)
+
+		servers := []*Server{sqlStopped, sqlRunning}
+
+		Convey("It picks it by default", func() {
+			balancer := &Balancer{config: &Config{}, servers: servers}
+			So(balancer.PickServer(), ShouldPointTo, sqlStopped)
+		})
+
+		Convey("It excludes it when configured", func() {
+			balancer := &Balancer{config: &Config{ExcludeSQLThreadStopped: true}, servers: servers}
+			So(balancer.PickServer(), ShouldPointTo, sqlRunning)
+
+			balancer = &Balancer{config: &Config{ExcludeSQLThreadStopped: true}, servers: []*Server{sqlStopped}}
+			So(balancer.PickServer(), ShouldBeNil)
+		})
+	})
+}
+
+func TestPickServerWithSubSecondLag(t *testing.T) {
+	Convey("Given replicas with sub-second replication lag", t, func() {
+		newReplica := func(name string, lag time.Duration, runningConnections int) *Server {
+			server := &Server{name: name, health: &ServerHealth{}}
+			server.health.setUP(nil, true, false, &lag, &runningConnections, &runningConnections, nil)
+			return server
+		}
+
+		fast := newReplica("fast", 50*time.Millisecond, 10)
+		slow := newReplica("slow", 900*time.Millisecond, 0)
+
+		Convey("It tells them apart", func() {
+			balancer := &Balancer{config: &Config{}, servers: []*Server{slow, fast}}
+			So(balancer.PickServer(), ShouldPointTo, fast)
+		})
+
+		Convey("It spreads the load within the lag tolerance", func() {
+			balancer := &Balancer{config: &Config{MaxLagTolerance: time.Second}, servers: []*Server{slow, fast}}
+			So(balancer.PickServer(), ShouldPointTo, slow)
+		})
+	})
+}
+
+func TestPickServerWithLocality(t *testing.T) {
+	Convey("Given replicas in several zones", t, func() {
+		newReplica := func(name, zone, region string, runningConnections int) *Server {
+			server := &Server{
+				name:           name,
+				serverSettings: ServerSettings{Name: name, Zone: zone, Region: region},
+				health:         &ServerHealth{},
+			}
+			lag := time.Duration(0)
+			server.health.setUP(nil, true, false, &lag, &runningConnections, &runningConnections, nil)
+			return server
+		}
+
+		localZone := newReplica("local-zone", "us-east-1a", "us-east-1", 20)
+		localRegion := newReplica("local-region", "us-east-1b", "us-east-1", 5)
+		remote := newReplica("remote", "eu-west-1a", "eu-west-1", 10)
+		config := &Config{LocalZone: "us-east-1a", LocalRegion: "us-east-1"}
+		balancer := &Balancer{config: config, servers: Servers{remote, localRegion, localZone}}
+
+		Convey("It prefers the local zone", func() {
+			So(balancer.PickServer(), ShouldPointTo, localZone)
+		})
+
+		Convey("It falls back to the local region, then to any zone", func() {
+			localZone.health.setDown(nil, false, false, nil, nil, nil, nil)
+			So(balancer.PickServer(), ShouldPointTo, localRegion)
+
+			localRegion.health.setDown(nil, false, false, nil, nil, nil, nil)
+			So(balancer.PickServer(), ShouldPointTo, remote)
+		})
+
+		Convey("It spills when the local zone is too busy", func() {
+			config.ZoneSpillRatio = 1.5
+			So(balancer.eligibleServers(), ShouldResemble, Servers{localRegion, localZone})
+			So(balancer.PickServer(), ShouldPointTo, localRegion)
+
+			config.ZoneSpillRatio = 3
+			So(balancer.PickServer(), ShouldPointTo, localZone)
+		})
+
+		Convey("It does not spill to idle zones under light load", func() {
+			config.ZoneSpillRatio = 2
+			config.LocalRegion = ""
+			one, zero := 1, 0
+			localZone.health.runningConnections = &one
+			localRegion.health.runningConnections = &zero
+			remote.health.runningConnections = &zero
+			So(balancer.PickServer(), ShouldPointTo, localZone)
+		})
+
+		Convey("Without a local zone every replica is eligible", func() {
+			config.LocalZone, config.LocalRegion = "", ""
+			So(balancer.eligibleServers(), ShouldHaveLength, 3)
+			So(balancer.PickServer(), ShouldPointTo, localRegion)
+		})
+	})
+}
@@ -0,0 +1,130 @@
+package balancer
+
+import (
+	"database/sql/driver"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// MySQL error numbers that tell the server is unable to serve queries
+const (
+	mysqlErrTooManyConnections = 1040
+	mysqlErrLockWaitTimeout    = 1205
+	mysqlErrOptionPrevents     = 1290 // --read-only
+	mysqlErrReadOnlyMode       = 1836
+)
+
+var mysqlErrorNumberRegexp = regexp.MustCompile(`^Error (\d+)`)
+
+// mysqlErrorNumber returns the number of a go-sql-driver/mysql error, or 0
+func mysqlErrorNumber(err error) int {
+	match := mysqlErrorNumberRegexp.FindStringSubmatch(err.Error())
+	if match == nil {
+		return 0
+	}
+	number, _ := strconv.Atoi(match[1])
+	return number
+}
+
+// isServerError tells whether err means the server, rather than the query,
+// is at fault: refused connections, too many connections, read only servers
+// and lock wait timeouts. driver.ErrBadConn is not, as it only means a pooled
+// connection went stale and database/sql retries on another one.
+func isServerError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) ||
+		strings.Contains(err.Error(), "connection refused") {
+		return true
+	}
+
+	switch mysqlErrorNumber(err) {
+	case mysqlErrTooManyConnections, mysqlErrLockWaitTimeout, mysqlErrOptionPrevents, mysqlErrReadOnlyMode:
+		return true
+	}
+	return false
+}
+
+// circuitBreaker ejects a server for cooldown after threshold consecutive
+// server errors. A threshold of 0 disables it.
+type circuitBreaker struct {
+	sync.Mutex
+
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+}
+
+// failure records a server error and reports whether it opened the breaker
+func (c *circuitBreaker) failure() bool {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.threshold <= 0 {
+		return false
+	}
+
+	c.failures++
+	if c.failures < c.threshold {
+		return false
+	}
+
+	c.failures = 0
+	c.openUntil = time.Now().Add(c.cooldown)
+	return true
+}
+
+func (c *circuitBreaker) success() {
+	c.Lock()
+	defer c.Unlock()
+	c.failures = 0
+}
+
+func (c *circuitBreaker) isOpen() bool {
+	c.Lock()
+	defer c.Unlock()
+	return time.Now().Before(c.openUntil)
+}
+
+// ReportError reports the error of a query run on the server. Server errors
+// (see Config.CircuitBreakerThreshold) count towards ejecting the server from
+// PickServer, other errors are ignored.
+func (s *Server) ReportError(err error) {
+	if !isServerError(err) {
+		return
+	}
+
+	if s.breaker.failure() && s.recheck != nil {
+		go s.recheck()
+	}
+}
+
+// ReportSuccess reports a query run successfully on the server
+func (s *Server) ReportSuccess() {
+	s.breaker.success()
+}
+
+// IsEjected returns if the server is ejected by its circuit breaker
+func (s *Server) IsEjected() bool {
+	return s.breaker.isOpen()
+}
+
+// report reports err, if any, and returns it
+func (s *Server) report(err error) error {
+	switch err {
+	case nil:
+		s.ReportSuccess()
+	case driver.ErrSkip:
+	default:
+		s.ReportError(err)
+	}
+	return err
+}
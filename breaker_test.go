@@ -0,0 +1,114 @@
+package balancer
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIsServerError(t *testing.T) {
+	Convey("Given query errors", t, func() {
+		Convey("It should classify server errors", func() {
+			So(isServerError(fmt.Errorf("dial: %w", syscall.ECONNREFUSED)), ShouldBeTrue)
+			So(isServerError(errors.New("dial tcp 10.0.0.1:3306: connect: connection refused")), ShouldBeTrue)
+			So(isServerError(errors.New("Error 1040: Too many connections")), ShouldBeTrue)
+			So(isServerError(errors.New("Error 1040 (08004): Too many connections")), ShouldBeTrue)
+			So(isServerError(errors.New("Error 1205: Lock wait timeout exceeded; try restarting transaction")), ShouldBeTrue)
+			So(isServerError(errors.New(
+				"Error 1290: The MySQL server is running with the --read-only option so it cannot execute this statement",
+			)), ShouldBeTrue)
+			So(isServerError(errors.New("Error 1836: Running in read-only mode")), ShouldBeTrue)
+		})
+
+		Convey("It should ignore query errors", func() {
+			So(isServerError(nil), ShouldBeFalse)
+			So(isServerError(sql.ErrNoRows), ShouldBeFalse)
+			So(isServerError(driver.ErrBadConn), ShouldBeFalse)
+			So(isServerError(context.Canceled), ShouldBeFalse)
+			So(isServerError(errors.New("Error 1064: You have an error in your SQL syntax")), ShouldBeFalse)
+			So(isServerError(errors.New("Error 1062: Duplicate entry '1' for key 'PRIMARY'")), ShouldBeFalse)
+		})
+	})
+}
+
+func TestReportError(t *testing.T) {
+	Convey("Given a balancer with a circuit breaker tripping after 2 server errors", t, func() {
+		replica1, _ := getMockServer(t, "replica1")
+		replica2, _ := getMockServer(t, "replica2")
+		for _, server := range []*Server{replica1, replica2} {
+			server.breaker = circuitBreaker{threshold: 2, cooldown: time.Minute}
+		}
+		balancer := &Balancer{config: &Config{}, servers: []*Server{replica1, replica2}}
+
+		rechecked := make(chan struct{}, 1)
+		replica1.recheck = func() { rechecked <- struct{}{} }
+
+		serverErr := errors.New("Error 1040: Too many connections")
+
+		Convey("It should eject the server and check it right away", func() {
+			replica1.ReportError(serverErr)
+			So(replica1.IsEjected(), ShouldBeFalse)
+
+			replica1.ReportError(serverErr)
+			So(replica1.IsEjected(), ShouldBeTrue)
+			So(balancer.eligibleServers(), ShouldResemble, Servers{replica2})
+			So(balancer.PickServer(), ShouldPointTo, replica2)
+
+			select {
+			case <-rechecked:
+			case <-time.After(time.Second):
+				t.Fatal("server was not checked")
+			}
+		})
+
+		Convey("It should only count consecutive server errors", func() {
+			replica1.ReportError(serverErr)
+			replica1.ReportSuccess()
+			replica1.ReportError(errors.New("Error 1064: You have an error in your SQL syntax"))
+			replica1.ReportError(serverErr)
+			So(replica1.IsEjected(), ShouldBeFalse)
+		})
+
+		Convey("It should take the server back after the cooldown", func() {
+			replica1.breaker.cooldown = 10 * time.Millisecond
+			replica1.ReportError(serverErr)
+			replica1.ReportError(serverErr)
+			So(replica1.IsEjected(), ShouldBeTrue)
+
+			time.Sleep(20 * time.Millisecond)
+			So(replica1.IsEjected(), ShouldBeFalse)
+		})
+
+		Convey("It should do nothing when disabled", func() {
+			replica1.breaker.threshold = 0
+			replica1.ReportError(serverErr)
+			replica1.ReportError(serverErr)
+			So(replica1.IsEjected(), ShouldBeFalse)
+		})
+	})
+
+	Convey("Given a connector over a replica with a circuit breaker", t, func() {
+		server, mock := getMockServer(t, "replica")
+		server.breaker = circuitBreaker{threshold: 1, cooldown: time.Minute}
+		balancer := &Balancer{config: &Config{}, servers: []*Server{server}}
+
+		db := sql.OpenDB(Connector(balancer))
+		defer db.Close()
+
+		Convey("Query errors should be reported", func() {
+			mock.ExpectQuery("SELECT 1").WillReturnError(errors.New("Error 1205: Lock wait timeout exceeded"))
+
+			_, err := db.Query("SELECT 1")
+			So(err, ShouldNotBeNil)
+			So(server.IsEjected(), ShouldBeTrue)
+			So(balancer.PickServer(), ShouldBeNil)
+		})
+	})
+}
@@ -1,6 +1,8 @@
 package balancer
 
 import (
+	"fmt"
+	"strconv"
 	"time"
 )
 
@@ -9,25 +11,177 @@ type ReplicationMode int
 const (
 	ReplicationModeSingleSource ReplicationMode = iota
 	ReplicationModeMultiSourceWriteSet
+	// ReplicationModeGroupReplication is MySQL Group Replication (InnoDB
+	// Cluster), only ONLINE members are eligible
+	ReplicationModeGroupReplication
 )
 
+var replicationModeNames = []string{"single_source", "multi_source_write_set", "group_replication"}
+
+// UnmarshalText parses single_source, multi_source_write_set or
+// group_replication
+func (m *ReplicationMode) UnmarshalText(text []byte) error {
+	value, err := parseEnum("replication mode", replicationModeNames, text)
+	*m = ReplicationMode(value)
+	return err
+}
+
+// LagFallbackPolicy tells PickServer what to do when every replica is beyond
+// Config.MaxSecondsBehindMaster
+type LagFallbackPolicy int
+
+const (
+	// LagFallbackNone picks no server
+	LagFallbackNone LagFallbackPolicy = iota
+	// LagFallbackLeastLagged picks among the less lagged replicas
+	LagFallbackLeastLagged
+	// LagFallbackPrimary picks the primary, as Balancer.PickWriter does
+	LagFallbackPrimary
+)
+
+var lagFallbackPolicyNames = []string{"none", "least_lagged", "primary"}
+
+// UnmarshalText parses none, least_lagged or primary
+func (p *LagFallbackPolicy) UnmarshalText(text []byte) error {
+	value, err := parseEnum("lag fallback policy", lagFallbackPolicyNames, text)
+	*p = LagFallbackPolicy(value)
+	return err
+}
+
+// ServerRole tells whether a server takes reads or writes
+type ServerRole int
+
+const (
+	// ServerRoleReplica servers are read replicas returned by PickServer
+	ServerRoleReplica ServerRole = iota
+	// ServerRolePrimary servers are writers returned by PickWriter. A primary is
+	// healthy when it is reachable and read_only is OFF.
+	ServerRolePrimary
+)
+
+var serverRoleNames = []string{"replica", "primary"}
+
+// UnmarshalText parses replica or primary
+func (r *ServerRole) UnmarshalText(text []byte) error {
+	value, err := parseEnum("server role", serverRoleNames, text)
+	*r = ServerRole(value)
+	return err
+}
+
+// parseEnum returns the index of text in names. The index itself is accepted
+// as well, as the constants' values.
+func parseEnum(kind string, names []string, text []byte) (int, error) {
+	for i, name := range names {
+		if string(text) == name {
+			return i, nil
+		}
+	}
+	if i, err := strconv.Atoi(string(text)); err == nil && i >= 0 && i < len(names) {
+		return i, nil
+	}
+	return 0, fmt.Errorf("balancer: invalid %s %q", kind, text)
+}
+
 // Config configuration options for the balancer
 type Config struct {
-	CheckInterval   int64
-	StartCheck      bool
-	TraceOn         bool
-	Logger          Logger
-	ServersSettings []ServerSettings
-	StartupWait     time.Duration
-	ReplicationMode ReplicationMode
+	CheckInterval   int64            `yaml:"check_interval"`
+	StartCheck      bool             `yaml:"start_check"`
+	TraceOn         bool             `yaml:"trace_on"`
+	Logger          Logger           `yaml:"-"`
+	ServersSettings []ServerSettings `yaml:"servers"`
+	StartupWait     time.Duration    `yaml:"startup_wait"`
+	ReplicationMode ReplicationMode  `yaml:"replication_mode"`
+	Strategy        Strategy         `yaml:"-"` // defaults to NewDefaultStrategy
+
+	// MaxSecondsBehindMaster excludes replicas lagging more than it or not
+	// reporting their lag (ReplicationModeSingleSource only). 0 disables it.
+	MaxSecondsBehindMaster int `yaml:"max_seconds_behind_master"`
+	// MaxLagTolerance makes replicas lagging up to MaxLagTolerance more than
+	// the less lagged one eligible for the default strategy. When 0, every
+	// replica within MaxSecondsBehindMaster is eligible, or only the less
+	// lagged ones if that is not set either. Set it when the lag has sub-second
+	// precision (HeartbeatTable), otherwise replicas seldom tie.
+	MaxLagTolerance time.Duration `yaml:"max_lag_tolerance"`
+	// LagFallback is used when no replica is within MaxSecondsBehindMaster or
+	// no replica is UP at all
+	LagFallback LagFallbackPolicy `yaml:"lag_fallback"`
+	// ExcludeSQLThreadStopped excludes replicas whose SQL thread is not known
+	// to be running (ReplicationModeSingleSource only)
+	ExcludeSQLThreadStopped bool `yaml:"exclude_sql_thread_stopped"`
+	// HeartbeatTable is a pt-heartbeat style table, such as percona.heartbeat,
+	// used to measure the replication lag of every replica instead of
+	// Seconds_Behind_Master (ReplicationModeSingleSource only). See
+	// ServerSettings.HeartbeatTable.
+	HeartbeatTable string `yaml:"heartbeat_table"`
+	// HeartbeatInterval makes the balancer write the heartbeat on the primary
+	// (REPLACE INTO HeartbeatTable (ts, server_id)) at this interval. 0
+	// disables it, for instance when pt-heartbeat runs on the primary.
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval"`
+	// HealthCheckTimeout bounds every health check, including connecting to
+	// the server. A check that times out sets the server DOWN with a
+	// *HealthCheckTimeoutError. Defaults to CheckInterval.
+	HealthCheckTimeout time.Duration `yaml:"health_check_timeout"`
+	// HealthCheckRise and HealthCheckFall are the number of consecutive
+	// successful or failed checks needed to set a server UP or DOWN, as
+	// HAProxy's rise and fall. The first check always decides. Default to 1.
+	HealthCheckRise int `yaml:"health_check_rise"`
+	HealthCheckFall int `yaml:"health_check_fall"`
+	// CircuitBreakerThreshold ejects a replica from PickServer for
+	// CircuitBreakerCooldown after this many consecutive server errors
+	// reported by Server.ReportError or Connector connections, and checks its
+	// health right away. 0 disables it.
+	CircuitBreakerThreshold int `yaml:"circuit_breaker_threshold"`
+	// CircuitBreakerCooldown defaults to CheckInterval
+	CircuitBreakerCooldown time.Duration `yaml:"circuit_breaker_cooldown"`
+	// LocalZone and LocalRegion make PickServer prefer the eligible replicas
+	// in the same zone, then in the same region (see ServerSettings.Zone),
+	// using other replicas only when there is none
+	LocalZone   string `yaml:"local_zone"`
+	LocalRegion string `yaml:"local_region"`
+	// ZoneSpillRatio also spills to the next zone or region when the local
+	// replicas are this many times busier than the others, comparing running
+	// connections per unit of weight. 0 disables it.
+	ZoneSpillRatio float64 `yaml:"zone_spill_ratio"`
+	// DrainTimeout is how long a server removed by Balancer.RemoveServer or
+	// replaced by Balancer.UpdateServer may finish its running queries before
+	// its connections are closed. Defaults to 30s.
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
+	// ConsistencyWaitTimeout is how long PickServerAtLeast waits for a replica
+	// to execute a consistency token before falling back to the primary
+	ConsistencyWaitTimeout time.Duration `yaml:"consistency_wait_timeout"`
+	// Discoverers add and remove servers besides ServersSettings, see
+	// Discoverer
+	Discoverers []Discoverer `yaml:"-"`
+	// DNSDiscovery adds and removes servers as the records of a DNS name
+	// change, as one more discoverer
+	DNSDiscovery *DNSDiscovery `yaml:"dns_discovery"`
 }
 
 // ServerSettings servers' configuration options
 type ServerSettings struct {
-	Name             string
-	DSN              string
-	ReplicationDSN   string
-	MaxIdleConns     int
-	MaxOpenConns     int
-	MaxLifetimeConns time.Duration
+	Name             string        `yaml:"name"`
+	DSN              string        `yaml:"dsn"`
+	ReplicationDSN   string        `yaml:"replication_dsn"`
+	MaxIdleConns     int           `yaml:"max_idle_conns"`
+	MaxOpenConns     int           `yaml:"max_open_conns"`
+	MaxLifetimeConns time.Duration `yaml:"max_lifetime_conns"`
+	Role             ServerRole    `yaml:"role"`
+
+	// Weight is the server's capacity relative to the other servers, such as
+	// its number of cores. Connection counts are divided by it when comparing
+	// servers, and NewSmoothWeightedRoundRobinStrategy and NewWeightedStrategy
+	// pick servers in proportion to it. Defaults to 1, see Balancer.SetWeight.
+	Weight int `yaml:"weight"`
+
+	// Zone and Region locate the server, see Config.LocalZone
+	Zone   string `yaml:"zone"`
+	Region string `yaml:"region"`
+
+	// ReplicationChannels names the replication channels (MariaDB connections)
+	// whose status is taken into account. When empty every channel is, using
+	// the highest lag.
+	ReplicationChannels []string `yaml:"replication_channels"`
+
+	// HeartbeatTable overrides Config.HeartbeatTable for this server
+	HeartbeatTable string `yaml:"heartbeat_table"`
 }
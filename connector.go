@@ -0,0 +1,169 @@
+package balancer
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+)
+
+// ErrNoServerAvailable is returned when the balancer has no server to pick
+var ErrNoServerAvailable = errors.New("balancer: no server available")
+
+// Connector returns a driver.Connector that opens each new connection against
+// the server b picks at that moment, so sql.OpenDB(balancer.Connector(b))
+// returns a load balanced *sql.DB. Connections are evicted from the pool once
+// their server goes DOWN.
+func Connector(b *Balancer) driver.Connector {
+	return &connector{pick: b.PickServer}
+}
+
+type connector struct {
+	pick func() *Server
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	server := c.pick()
+	if server == nil {
+		return nil, ErrNoServerAvailable
+	}
+
+	conn, err := server.openConn(ctx)
+	if err != nil {
+		return nil, server.report(err)
+	}
+
+	return &serverConn{Conn: conn, server: server}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return connectorDriver{}
+}
+
+// connectorDriver only exists to satisfy driver.Connector, connections are
+// always opened through the connector
+type connectorDriver struct{}
+
+func (connectorDriver) Open(name string) (driver.Conn, error) {
+	return nil, errors.New("balancer: connections must be opened through balancer.Connector")
+}
+
+// openConn opens a new driver connection to the server's DSN, using the same
+// driver as its read connection
+func (s *Server) openConn(ctx context.Context) (driver.Conn, error) {
+	s.connLock.Lock()
+	connection := s.connection
+	s.connLock.Unlock()
+
+	if connection == nil || connection.Db == nil {
+		return nil, fmt.Errorf("balancer: server %s is not connected", s.name)
+	}
+
+	sqlDriver := connection.Db.Driver()
+	if driverCtx, ok := sqlDriver.(driver.DriverContext); ok {
+		dsnConnector, err := driverCtx.OpenConnector(s.serverSettings.DSN)
+		if err != nil {
+			return nil, err
+		}
+		return dsnConnector.Connect(ctx)
+	}
+
+	return sqlDriver.Open(s.serverSettings.DSN)
+}
+
+// serverConn is a driver.Conn pinned to a server. It reports itself invalid
+// when the server goes DOWN, is ejected or removed so database/sql drops it
+// from the pool, and reports query errors and latency to the server.
+type serverConn struct {
+	driver.Conn
+	server *Server
+}
+
+// IsValid implements driver.Validator
+func (c *serverConn) IsValid() bool {
+	if !c.server.health.IsUP() || c.server.IsEjected() || c.server.isRemoved() {
+		return false
+	}
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+// ResetSession implements driver.SessionResetter
+func (c *serverConn) ResetSession(ctx context.Context) error {
+	if !c.server.health.IsUP() || c.server.IsEjected() || c.server.isRemoved() {
+		return driver.ErrBadConn
+	}
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+// Ping implements driver.Pinger
+func (c *serverConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return c.server.report(pinger.Ping(ctx))
+	}
+	return nil
+}
+
+// PrepareContext implements driver.ConnPrepareContext
+func (c *serverConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err := preparer.PrepareContext(ctx, query)
+		return stmt, c.server.report(err)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	stmt, err := c.Conn.Prepare(query)
+	return stmt, c.server.report(err)
+}
+
+// BeginTx implements driver.ConnBeginTx
+func (c *serverConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err := beginner.BeginTx(ctx, opts)
+		return tx, c.server.report(err)
+	}
+	if opts.ReadOnly || opts.Isolation != driver.IsolationLevel(0) {
+		return nil, errors.New("balancer: driver does not support transaction options")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	tx, err := c.Conn.Begin()
+	return tx, c.server.report(err)
+}
+
+// QueryContext implements driver.QueryerContext
+func (c *serverConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if queryer, ok := c.Conn.(driver.QueryerContext); ok {
+		done := c.server.trackQuery()
+		rows, err := queryer.QueryContext(ctx, query, args)
+		done(err)
+		return rows, c.server.report(err)
+	}
+	return nil, driver.ErrSkip
+}
+
+// ExecContext implements driver.ExecerContext
+func (c *serverConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if execer, ok := c.Conn.(driver.ExecerContext); ok {
+		done := c.server.trackQuery()
+		result, err := execer.ExecContext(ctx, query, args)
+		done(err)
+		return result, c.server.report(err)
+	}
+	return nil, driver.ErrSkip
+}
+
+// CheckNamedValue implements driver.NamedValueChecker
+func (c *serverConn) CheckNamedValue(value *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(value)
+	}
+	return driver.ErrSkip
+}
@@ -0,0 +1,76 @@
+package balancer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-gorp/gorp/v3"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var mockServerSeq int64
+
+func getMockServer(t *testing.T, name string) (*Server, sqlmock.Sqlmock) {
+	t.Helper()
+
+	dsn := fmt.Sprintf("balancer_%s_%d@/db", name, atomic.AddInt64(&mockServerSeq, 1))
+	db, mock, err := sqlmock.NewWithDSN(dsn)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	server := &Server{
+		name:           name,
+		health:         &ServerHealth{},
+		serverSettings: ServerSettings{Name: name, DSN: dsn, ReplicationDSN: testReplicationDSN},
+		connection:     &gorp.DbMap{Db: db, Dialect: gorp.MySQLDialect{}},
+	}
+	server.health.setUP(nil, true, false, &[]time.Duration{0}[0], &[]int{0}[0], &[]int{0}[0], nil)
+
+	return server, mock
+}
+
+func TestConnector(t *testing.T) {
+	Convey("Given a balancer with one healthy server", t, func() {
+		server, mock := getMockServer(t, "replica")
+		balancer := &Balancer{config: &Config{}, servers: []*Server{server}}
+
+		db := sql.OpenDB(Connector(balancer))
+		defer db.Close()
+
+		Convey("It runs queries against the picked server", func() {
+			mock.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow(1))
+
+			var result int
+			So(db.QueryRow("SELECT 1").Scan(&result), ShouldBeNil)
+			So(result, ShouldEqual, 1)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("It evicts connections once the server goes down", func() {
+			conn, err := db.Conn(context.Background())
+			So(err, ShouldBeNil)
+
+			server.health.setDown(nil, false, false, nil, nil, nil, nil)
+			So(conn.Close(), ShouldBeNil)
+
+			_, err = db.Conn(context.Background())
+			So(err, ShouldEqual, ErrNoServerAvailable)
+		})
+	})
+
+	Convey("Given a balancer without healthy servers", t, func() {
+		balancer := &Balancer{config: &Config{}, servers: []*Server{ServerDownDueToMySQLConnection}}
+		db := sql.OpenDB(Connector(balancer))
+		defer db.Close()
+
+		Convey("It fails with ErrNoServerAvailable", func() {
+			So(db.Ping(), ShouldEqual, ErrNoServerAvailable)
+		})
+	})
+}
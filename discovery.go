@@ -0,0 +1,260 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"time"
+)
+
+const defaultDiscoveryInterval = 30 * time.Second
+
+// Discovery is the complete set of servers found by a Discoverer, or the error
+// that prevented finding them
+type Discovery struct {
+	ServersSettings []ServerSettings
+	Err             error
+}
+
+// Discoverer finds servers besides Config.ServersSettings, such as the
+// members of a service registry. Discover sends a Discovery on updates every
+// time the set of servers changes, until ctx is done. The balancer adds,
+// updates and removes the servers found by each Discoverer to match its last
+// Discovery, and keeps them when it has an Err.
+type Discoverer interface {
+	Discover(ctx context.Context, updates chan<- Discovery)
+}
+
+// discovery is a running Discoverer, owning the servers it found
+type discovery struct {
+	updates chan Discovery
+}
+
+// poll sends the servers found by lookup every interval when they changed or
+// lookup failed, until ctx is done. Each lookup is given the interval to
+// complete.
+func poll(
+	ctx context.Context, updates chan<- Discovery, interval time.Duration,
+	lookup func(ctx context.Context) ([]ServerSettings, error),
+) {
+	if interval <= 0 {
+		interval = defaultDiscoveryInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last []ServerSettings
+	sent := false
+	for {
+		lookupCtx, cancel := context.WithTimeout(ctx, interval)
+		serversSettings, err := lookup(lookupCtx)
+		cancel()
+
+		if err != nil || !sent || !reflect.DeepEqual(serversSettings, last) {
+			select {
+			case updates <- Discovery{ServersSettings: serversSettings, Err: err}:
+			case <-ctx.Done():
+				return
+			}
+			if err == nil {
+				last, sent = serversSettings, true
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type fileDiscoverer struct {
+	path     string
+	interval time.Duration
+}
+
+// NewFileDiscoverer returns a Discoverer reading the servers from a YAML or
+// JSON file every interval, listed under servers as in a config file (see
+// ParseConfig). A file that cannot be read or parsed keeps the servers found
+// so far.
+func NewFileDiscoverer(path string, interval time.Duration) Discoverer {
+	return &fileDiscoverer{path: path, interval: interval}
+}
+
+func (d *fileDiscoverer) Discover(ctx context.Context, updates chan<- Discovery) {
+	poll(ctx, updates, d.interval, d.lookup)
+}
+
+func (d *fileDiscoverer) lookup(ctx context.Context) ([]ServerSettings, error) {
+	data, err := os.ReadFile(d.path)
+	if err != nil {
+		return nil, fmt.Errorf("balancer: could not read servers: %s", err)
+	}
+	return parseServersSettings(data)
+}
+
+type httpDiscoverer struct {
+	url      string
+	interval time.Duration
+	client   *http.Client
+}
+
+// NewHTTPDiscoverer returns a Discoverer fetching the servers from url every
+// interval. The endpoint must answer 200 OK with a JSON (or YAML) document
+// listing them under servers, as NewFileDiscoverer's file. A nil client uses
+// http.DefaultClient.
+func NewHTTPDiscoverer(url string, interval time.Duration, client *http.Client) Discoverer {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpDiscoverer{url: url, interval: interval, client: client}
+}
+
+func (d *httpDiscoverer) Discover(ctx context.Context, updates chan<- Discovery) {
+	poll(ctx, updates, d.interval, d.lookup)
+}
+
+func (d *httpDiscoverer) lookup(ctx context.Context) ([]ServerSettings, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, d.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("balancer: could not fetch servers: %s", err)
+	}
+	request.Header.Set("Accept", "application/json")
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("balancer: could not fetch servers: %s", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("balancer: could not fetch servers: %s answered %s", d.url, response.Status)
+	}
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("balancer: could not fetch servers: %s", err)
+	}
+	return parseServersSettings(data)
+}
+
+// discoverers returns Config.Discoverers and Config.DNSDiscovery
+func (b *Balancer) discoverers() []Discoverer {
+	discoverers := b.config.Discoverers
+	if b.config.DNSDiscovery != nil {
+		dnsDiscovery := *b.config.DNSDiscovery
+		if dnsDiscovery.Interval <= 0 {
+			dnsDiscovery.Interval = time.Duration(b.config.CheckInterval) * time.Second
+		}
+		discoverers = append(discoverers[:len(discoverers):len(discoverers)], &dnsDiscovery)
+	}
+	return discoverers
+}
+
+// startDiscovery runs the discoverers until the balancer is closed. It waits
+// up to Config.StartupWait for their first discovery, whose servers are not
+// checked, so the first checks of the balancer include them.
+func (b *Balancer) startDiscovery(discoverers []Discoverer) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b.stopDiscovery = cancel
+
+	discoveries := make([]*discovery, len(discoverers))
+	for i, discoverer := range discoverers {
+		discoveries[i] = &discovery{updates: make(chan Discovery)}
+		go discoverer.Discover(ctx, discoveries[i].updates)
+	}
+
+	startup, cancelStartup := context.WithTimeout(ctx, b.startupWait())
+	defer cancelStartup()
+
+	for _, d := range discoveries {
+		// a discovery already sent is applied even once the wait expired
+		select {
+		case update := <-d.updates:
+			b.logDiscoveryError(b.applyDiscovery(d, update, false))
+		default:
+			select {
+			case update := <-d.updates:
+				b.logDiscoveryError(b.applyDiscovery(d, update, false))
+			case <-startup.Done():
+			}
+		}
+
+		go b.watchDiscovery(ctx, d)
+	}
+}
+
+// watchDiscovery applies the updates of d until ctx is done
+func (b *Balancer) watchDiscovery(ctx context.Context, d *discovery) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case update := <-d.updates:
+			b.logDiscoveryError(b.applyDiscovery(d, update, true))
+		}
+	}
+}
+
+// applyDiscovery adds, updates and removes the servers owned by d to match
+// update, checking the health of the new ones if check is set. Servers are
+// kept when the update is an error or invalid.
+func (b *Balancer) applyDiscovery(d *discovery, update Discovery, check bool) error {
+	if update.Err != nil {
+		return update.Err
+	}
+	if err := validateServersSettings("ServersSettings", update.ServersSettings).err(); err != nil {
+		return err
+	}
+
+	desired := make(map[string]bool, len(update.ServersSettings))
+	for _, serverSettings := range update.ServersSettings {
+		desired[serverSettings.Name] = true
+	}
+
+	var errs []error
+	current := b.GetServers()
+	for _, server := range current {
+		if server.discovery == d && !desired[server.name] {
+			errs = append(errs, b.RemoveServer(server.name))
+		}
+	}
+
+	for _, serverSettings := range update.ServersSettings {
+		server := current.byName(serverSettings.Name)
+		switch {
+		case server == nil:
+			server = b.newDiscoveredServer(d, serverSettings)
+			err := b.addServer(server)
+			if err == nil && check {
+				go server.CheckHealth(b.traceOn, b.logger)
+			}
+			errs = append(errs, err)
+		case server.discovery != d:
+			errs = append(errs, fmt.Errorf("balancer: discovered server %q already exists", serverSettings.Name))
+		case !reflect.DeepEqual(server.serverSettings, serverSettings):
+			errs = append(errs, b.updateServer(b.newDiscoveredServer(d, serverSettings)))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// newDiscoveredServer creates a server owned by d
+func (b *Balancer) newDiscoveredServer(d *discovery, serverSettings ServerSettings) *Server {
+	server := b.newServer(serverSettings)
+	server.discovery = d
+	return server
+}
+
+func (b *Balancer) logDiscoveryError(err error) {
+	if err != nil && b.logger != nil {
+		b.logger.Errorf("failed discovering servers: %s", err)
+	}
+}
@@ -0,0 +1,245 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// chanDiscoverer forwards the discoveries sent on it
+type chanDiscoverer struct {
+	discoveries chan Discovery
+	stopped     chan struct{}
+}
+
+func newChanDiscoverer() *chanDiscoverer {
+	return &chanDiscoverer{discoveries: make(chan Discovery, 1), stopped: make(chan struct{})}
+}
+
+func (d *chanDiscoverer) Discover(ctx context.Context, updates chan<- Discovery) {
+	defer close(d.stopped)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case discovery := <-d.discoveries:
+			select {
+			case updates <- discovery:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func serverNames(balancer *Balancer) []string {
+	var names []string
+	for _, server := range balancer.GetServers() {
+		names = append(names, server.GetName())
+	}
+	return names
+}
+
+func TestApplyDiscovery(t *testing.T) {
+	Convey("Given a balancer with discovered servers", t, func() {
+		static, _ := getMockServer(t, "static")
+		balancer := &Balancer{config: &Config{}, servers: Servers{static}}
+		d1, d2 := &discovery{}, &discovery{}
+
+		So(balancer.applyDiscovery(d1, Discovery{ServersSettings: []ServerSettings{
+			{Name: "replica1", DSN: testDSN, ReplicationDSN: testReplicationDSN},
+			{Name: "replica2", DSN: testDSN, ReplicationDSN: testReplicationDSN},
+		}}, false), ShouldBeNil)
+		So(balancer.applyDiscovery(d2, Discovery{ServersSettings: []ServerSettings{
+			{Name: "replica3", DSN: testDSN, ReplicationDSN: testReplicationDSN},
+		}}, false), ShouldBeNil)
+		So(serverNames(balancer), ShouldResemble, []string{"static", "replica1", "replica2", "replica3"})
+
+		Convey("It should add, update and remove the servers of a discoverer", func() {
+			replica1 := balancer.GetServers()[1]
+			So(balancer.applyDiscovery(d1, Discovery{ServersSettings: []ServerSettings{
+				{Name: "replica1", DSN: testDSN, ReplicationDSN: testReplicationDSN},
+				{Name: "replica4", DSN: testDSN, ReplicationDSN: testReplicationDSN, Weight: 2},
+			}}, true), ShouldBeNil)
+
+			So(serverNames(balancer), ShouldResemble, []string{"static", "replica1", "replica3", "replica4"})
+			So(balancer.GetServers()[1] == replica1, ShouldBeTrue)
+			So(balancer.GetServers()[3].GetWeight(), ShouldEqual, 2)
+
+			So(balancer.applyDiscovery(d1, Discovery{ServersSettings: []ServerSettings{
+				{Name: "replica1", DSN: testDSN, ReplicationDSN: testReplicationDSN, Weight: 3},
+			}}, true), ShouldBeNil)
+			So(serverNames(balancer), ShouldResemble, []string{"static", "replica1", "replica3"})
+			So(balancer.GetServers()[1] == replica1, ShouldBeFalse)
+			So(balancer.GetServers()[1].GetWeight(), ShouldEqual, 3)
+			So(balancer.GetServers()[1].discovery == d1, ShouldBeTrue)
+		})
+
+		Convey("It should keep the servers on errors and invalid discoveries", func() {
+			So(balancer.applyDiscovery(d1, Discovery{Err: errors.New("unavailable")}, true), ShouldNotBeNil)
+			So(balancer.applyDiscovery(d1, Discovery{ServersSettings: []ServerSettings{{Name: "replica1"}}}, true), ShouldNotBeNil)
+			So(serverNames(balancer), ShouldResemble, []string{"static", "replica1", "replica2", "replica3"})
+		})
+
+		Convey("It should not take over other servers", func() {
+			So(balancer.applyDiscovery(d1, Discovery{ServersSettings: []ServerSettings{
+				{Name: "static", DSN: testDSN, ReplicationDSN: testReplicationDSN},
+				{Name: "replica3", DSN: testDSN, ReplicationDSN: testReplicationDSN},
+			}}, true), ShouldNotBeNil)
+
+			servers := balancer.GetServers()
+			So(serverNames(balancer), ShouldResemble, []string{"static", "replica3"})
+			So(servers[0] == static, ShouldBeTrue)
+			So(servers[1].discovery == d2, ShouldBeTrue)
+		})
+
+		Convey("Reconcile should leave the discovered servers alone", func() {
+			So(balancer.Reconcile(&Config{ServersSettings: []ServerSettings{{Name: "static2", DSN: testDSN, ReplicationDSN: testReplicationDSN}}}), ShouldBeNil)
+			So(serverNames(balancer), ShouldResemble, []string{"replica1", "replica2", "replica3", "static2"})
+		})
+	})
+}
+
+func TestDiscoverers(t *testing.T) {
+	Convey("Given a new balancer with discoverers", t, func() {
+		discoverer := newChanDiscoverer()
+		discoverer.discoveries <- Discovery{ServersSettings: []ServerSettings{{Name: "replica1", DSN: testDSN, ReplicationDSN: testReplicationDSN}}}
+		silent := newChanDiscoverer()
+
+		balancer := New(&Config{
+			StartupWait:     100 * time.Millisecond,
+			ServersSettings: []ServerSettings{{Name: "static", DSN: testDSN, ReplicationDSN: testReplicationDSN}},
+			Discoverers:     []Discoverer{silent, discoverer},
+		})
+
+		Convey("It should start with the first discovery", func() {
+			So(serverNames(balancer), ShouldResemble, []string{"static", "replica1"})
+			balancer.Close()
+		})
+
+		Convey("It should apply the next discoveries", func() {
+			discoverer.discoveries <- Discovery{ServersSettings: []ServerSettings{{Name: "replica2", DSN: testDSN, ReplicationDSN: testReplicationDSN}}}
+			deadline := time.Now().Add(2 * time.Second)
+			for len(balancer.GetServers()) != 2 || balancer.GetServers()[1].GetName() != "replica2" {
+				if time.Now().After(deadline) {
+					break
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			So(serverNames(balancer), ShouldResemble, []string{"static", "replica2"})
+			balancer.Close()
+		})
+
+		Convey("Closing the balancer should stop the discoverers", func() {
+			balancer.Close()
+			for _, d := range []*chanDiscoverer{discoverer, silent} {
+				stopped := false
+				select {
+				case <-d.stopped:
+					stopped = true
+				case <-time.After(2 * time.Second):
+				}
+				So(stopped, ShouldBeTrue)
+			}
+		})
+	})
+}
+
+// discover runs discoverer and returns its first discovery, then a function
+// returning the next ones, or nil if there is none within a second
+func discover(discoverer Discoverer) (Discovery, func() *Discovery, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	updates := make(chan Discovery)
+	go discoverer.Discover(ctx, updates)
+
+	next := func() *Discovery {
+		select {
+		case discovery := <-updates:
+			return &discovery
+		case <-time.After(time.Second):
+			return nil
+		}
+	}
+	return *next(), next, cancel
+}
+
+func TestFileDiscoverer(t *testing.T) {
+	Convey("Given a file discoverer", t, func() {
+		path := filepath.Join(t.TempDir(), "servers.yaml")
+		// replace the file at once, so it is never read half written
+		write := func(data string) {
+			So(os.WriteFile(path+".tmp", []byte(data), 0o600), ShouldBeNil)
+			So(os.Rename(path+".tmp", path), ShouldBeNil)
+		}
+		write("servers: [{name: replica1, dsn: user@/db, replication_dsn: repl@/, max_lifetime_conns: 1m}]")
+
+		first, next, stop := discover(NewFileDiscoverer(path, 10*time.Millisecond))
+		defer stop()
+
+		Convey("It should read the servers right away", func() {
+			So(first.Err, ShouldBeNil)
+			So(first.ServersSettings, ShouldResemble, []ServerSettings{
+				{Name: "replica1", DSN: testDSN, ReplicationDSN: testReplicationDSN, MaxLifetimeConns: time.Minute},
+			})
+		})
+
+		Convey("It should read the file again when it changes", func() {
+			write(`{"servers": [{"name": "replica2", "dsn": "user@/db", "replication_dsn": "repl@/"}]}`)
+			discovery := next()
+			So(discovery, ShouldNotBeNil)
+			So(discovery.Err, ShouldBeNil)
+			So(discovery.ServersSettings, ShouldResemble, []ServerSettings{{Name: "replica2", DSN: testDSN, ReplicationDSN: testReplicationDSN}})
+		})
+
+		Convey("It should report invalid and missing files", func() {
+			write("servers: [{name: replica2}]")
+			discovery := next()
+			So(discovery, ShouldNotBeNil)
+			So(discovery.Err, ShouldNotBeNil)
+
+			So(os.Remove(path), ShouldBeNil)
+			discovery = next()
+			So(discovery, ShouldNotBeNil)
+			So(discovery.Err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestHTTPDiscoverer(t *testing.T) {
+	Convey("Given an HTTP discoverer", t, func() {
+		var failing int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.LoadInt32(&failing) == 1 {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"servers": [{"name": "replica1", "dsn": "user@/db", "replication_dsn": "repl@/", "role": "replica"}]}`))
+		}))
+		defer server.Close()
+
+		first, next, stop := discover(NewHTTPDiscoverer(server.URL, 10*time.Millisecond, nil))
+		defer stop()
+
+		Convey("It should fetch the servers", func() {
+			So(first.Err, ShouldBeNil)
+			So(first.ServersSettings, ShouldResemble, []ServerSettings{{Name: "replica1", DSN: testDSN, ReplicationDSN: testReplicationDSN}})
+		})
+
+		Convey("It should report failures", func() {
+			atomic.StoreInt32(&failing, 1)
+			discovery := next()
+			So(discovery, ShouldNotBeNil)
+			So(discovery.Err, ShouldNotBeNil)
+			So(discovery.Err.Error(), ShouldContainSubstring, "503")
+		})
+	})
+}
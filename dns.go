@@ -0,0 +1,121 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultDNSPort = 3306
+
+// Resolver looks up the DNS records of DNSDiscovery, *net.Resolver
+// implements it
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// DNSDiscovery is a Discoverer adding a server for every address a DNS name
+// resolves to, and removing it once the name no longer resolves to it
+type DNSDiscovery struct {
+	// Name is looked up as an SRV record when it starts with an underscore,
+	// such as _mysql._tcp.replicas.example.com, and as A/AAAA records
+	// otherwise
+	Name string `yaml:"name"`
+	// Port of the A/AAAA records' addresses, defaults to 3306
+	Port int `yaml:"port"`
+	// Template holds the settings of the discovered servers. {host}, {port}
+	// and {addr} (host:port) are replaced in its DSN and ReplicationDSN, as
+	// user:pass@tcp({addr})/db. Servers are named after their addr and SRV
+	// records weigh them unless Template.Weight is set.
+	Template ServerSettings `yaml:"template"`
+	// Interval between lookups, defaults to Config.CheckInterval in
+	// Config.DNSDiscovery and to 30s otherwise. A failed lookup keeps the
+	// servers found by the previous one.
+	Interval time.Duration `yaml:"interval"`
+	// Resolver defaults to net.DefaultResolver
+	Resolver Resolver `yaml:"-"`
+}
+
+// validate returns the problems of d, located under field
+func (d *DNSDiscovery) validate(field string) ConfigErrors {
+	var errs ConfigErrors
+
+	if d.Name == "" {
+		errs.add(field+".Name", "required")
+	}
+	if d.Port < 0 || d.Port > 65535 {
+		errs.add(field+".Port", "invalid port %d", d.Port)
+	}
+	if d.Interval < 0 {
+		errs.add(field+".Interval", "negative duration %s", d.Interval)
+	}
+
+	// check the settings the template gives to a server
+	return append(errs, validateServerSettings(field+".Template", d.serverSettings("localhost", defaultDNSPort))...)
+}
+
+func (d *DNSDiscovery) resolver() Resolver {
+	if d.Resolver != nil {
+		return d.Resolver
+	}
+	return net.DefaultResolver
+}
+
+// resolve looks up Name and returns the settings of the servers found, sorted
+// by name
+func (d *DNSDiscovery) resolve(ctx context.Context) ([]ServerSettings, error) {
+	var serversSettings []ServerSettings
+
+	if strings.HasPrefix(d.Name, "_") {
+		_, records, err := d.resolver().LookupSRV(ctx, "", "", d.Name)
+		if err != nil {
+			return nil, fmt.Errorf("balancer: could not resolve %s: %s", d.Name, err)
+		}
+		for _, record := range records {
+			serverSettings := d.serverSettings(strings.TrimSuffix(record.Target, "."), int(record.Port))
+			if serverSettings.Weight == 0 {
+				serverSettings.Weight = int(record.Weight)
+			}
+			serversSettings = append(serversSettings, serverSettings)
+		}
+	} else {
+		hosts, err := d.resolver().LookupHost(ctx, d.Name)
+		if err != nil {
+			return nil, fmt.Errorf("balancer: could not resolve %s: %s", d.Name, err)
+		}
+		port := d.Port
+		if port == 0 {
+			port = defaultDNSPort
+		}
+		for _, host := range hosts {
+			serversSettings = append(serversSettings, d.serverSettings(host, port))
+		}
+	}
+
+	sort.Slice(serversSettings, func(i, j int) bool {
+		return serversSettings[i].Name < serversSettings[j].Name
+	})
+	return serversSettings, nil
+}
+
+// serverSettings returns Template's settings for a server at host:port
+func (d *DNSDiscovery) serverSettings(host string, port int) ServerSettings {
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	replacer := strings.NewReplacer("{host}", host, "{port}", strconv.Itoa(port), "{addr}", addr)
+
+	serverSettings := d.Template
+	serverSettings.Name = addr
+	serverSettings.DSN = replacer.Replace(d.Template.DSN)
+	serverSettings.ReplicationDSN = replacer.Replace(d.Template.ReplicationDSN)
+	return serverSettings
+}
+
+// Discover implements Discoverer, looking Name up every Interval
+func (d *DNSDiscovery) Discover(ctx context.Context, updates chan<- Discovery) {
+	poll(ctx, updates, d.Interval, d.resolve)
+}
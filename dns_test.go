@@ -0,0 +1,164 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type fakeResolver struct {
+	sync.Mutex
+	srv   []*net.SRV
+	hosts []string
+	err   error
+}
+
+func (r *fakeResolver) set(hosts []string, err error) {
+	r.Lock()
+	defer r.Unlock()
+	r.hosts, r.err = hosts, err
+}
+
+func (r *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.Lock()
+	defer r.Unlock()
+	return name, r.srv, r.err
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.Lock()
+	defer r.Unlock()
+	return r.hosts, r.err
+}
+
+func TestDNSDiscoveryResolve(t *testing.T) {
+	Convey("Given a DNS discovery", t, func() {
+		resolver := &fakeResolver{}
+		discovery := &DNSDiscovery{
+			Name:     "replicas.example.com",
+			Resolver: resolver,
+			Template: ServerSettings{
+				DSN:            "user:pass@tcp({addr})/db",
+				ReplicationDSN: "repl:pass@tcp({host}:{port})/",
+				MaxOpenConns:   10,
+				Zone:           "us-east-1a",
+			},
+		}
+
+		Convey("It should resolve A/AAAA records", func() {
+			resolver.set([]string{"10.0.0.2", "::1", "10.0.0.1"}, nil)
+
+			serversSettings, err := discovery.resolve(context.Background())
+			So(err, ShouldBeNil)
+			So(serversSettings, ShouldResemble, []ServerSettings{
+				{
+					Name:           "10.0.0.1:3306",
+					DSN:            "user:pass@tcp(10.0.0.1:3306)/db",
+					ReplicationDSN: "repl:pass@tcp(10.0.0.1:3306)/",
+					MaxOpenConns:   10,
+					Zone:           "us-east-1a",
+				},
+				{
+					Name:           "10.0.0.2:3306",
+					DSN:            "user:pass@tcp(10.0.0.2:3306)/db",
+					ReplicationDSN: "repl:pass@tcp(10.0.0.2:3306)/",
+					MaxOpenConns:   10,
+					Zone:           "us-east-1a",
+				},
+				{
+					Name:           "[::1]:3306",
+					DSN:            "user:pass@tcp([::1]:3306)/db",
+					ReplicationDSN: "repl:pass@tcp(::1:3306)/",
+					MaxOpenConns:   10,
+					Zone:           "us-east-1a",
+				},
+			})
+		})
+
+		Convey("It should use the configured port", func() {
+			resolver.set([]string{"10.0.0.1"}, nil)
+			discovery.Port = 3307
+
+			serversSettings, err := discovery.resolve(context.Background())
+			So(err, ShouldBeNil)
+			So(serversSettings, ShouldHaveLength, 1)
+			So(serversSettings[0].Name, ShouldEqual, "10.0.0.1:3307")
+			So(serversSettings[0].DSN, ShouldEqual, "user:pass@tcp(10.0.0.1:3307)/db")
+		})
+
+		Convey("It should resolve SRV records", func() {
+			discovery.Name = "_mysql._tcp.replicas.example.com"
+			resolver.srv = []*net.SRV{
+				{Target: "replica2.example.com.", Port: 3307, Weight: 0},
+				{Target: "replica1.example.com.", Port: 3306, Weight: 5},
+			}
+
+			serversSettings, err := discovery.resolve(context.Background())
+			So(err, ShouldBeNil)
+			So(serversSettings, ShouldHaveLength, 2)
+			So(serversSettings[0].Name, ShouldEqual, "replica1.example.com:3306")
+			So(serversSettings[0].DSN, ShouldEqual, "user:pass@tcp(replica1.example.com:3306)/db")
+			So(serversSettings[0].Weight, ShouldEqual, 5)
+			So(serversSettings[1].Name, ShouldEqual, "replica2.example.com:3307")
+			So(serversSettings[1].Weight, ShouldEqual, 0)
+
+			Convey("Unless the template sets the weight", func() {
+				discovery.Template.Weight = 2
+				serversSettings, err := discovery.resolve(context.Background())
+				So(err, ShouldBeNil)
+				So(serversSettings[0].Weight, ShouldEqual, 2)
+			})
+		})
+
+		Convey("It should fail when the lookup fails", func() {
+			resolver.set(nil, errors.New("no such host"))
+			serversSettings, err := discovery.resolve(context.Background())
+			So(err, ShouldNotBeNil)
+			So(serversSettings, ShouldBeNil)
+		})
+	})
+}
+
+func TestDNSDiscovery(t *testing.T) {
+	Convey("Given a new balancer discovering its replicas", t, func() {
+		resolver := &fakeResolver{hosts: []string{"10.0.0.1"}}
+		balancer := New(&Config{
+			StartupWait: time.Second,
+			DNSDiscovery: &DNSDiscovery{
+				Name:     "replicas.example.com",
+				Template: ServerSettings{DSN: "user:pass@tcp({addr})/db", ReplicationDSN: "repl:pass@tcp({addr})/"},
+				Interval: 10 * time.Millisecond,
+				Resolver: resolver,
+			},
+		})
+		defer balancer.Close()
+
+		Convey("It should start with the discovered servers", func() {
+			servers := balancer.GetServers()
+			So(servers, ShouldHaveLength, 1)
+			So(servers[0].GetName(), ShouldEqual, "10.0.0.1:3306")
+		})
+
+		Convey("Reconcile should leave the discovered servers alone", func() {
+			So(balancer.Reconcile(&Config{ServersSettings: []ServerSettings{{Name: "static", DSN: testDSN, ReplicationDSN: testReplicationDSN}}}), ShouldBeNil)
+			servers := balancer.GetServers()
+			So(servers, ShouldHaveLength, 2)
+			So(servers[0].GetName(), ShouldEqual, "10.0.0.1:3306")
+			So(servers[1].GetName(), ShouldEqual, "static")
+		})
+
+		Convey("It should look the records up again", func() {
+			resolver.set([]string{"10.0.0.1", "10.0.0.2"}, nil)
+			deadline := time.Now().Add(2 * time.Second)
+			for len(balancer.GetServers()) != 2 && time.Now().Before(deadline) {
+				time.Sleep(5 * time.Millisecond)
+			}
+			So(balancer.GetServers(), ShouldHaveLength, 2)
+		})
+	})
+}
@@ -6,6 +6,7 @@ require (
 	github.com/DATA-DOG/go-sqlmock v1.3.3
 	github.com/go-gorp/gorp/v3 v3.0.4
 	github.com/smartystreets/goconvey v0.0.0-20190306220146-200a235640ff
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
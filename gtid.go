@@ -0,0 +1,124 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ConsistencyToken is a GTID set a replica must have executed to serve reads
+// consistent with the writes it was captured after
+type ConsistencyToken string
+
+// ErrNoConsistentServer is returned by PickServerAtLeast when neither a
+// replica nor the primary can serve the given token
+var ErrNoConsistentServer = errors.New("balancer: no server has executed the consistency token")
+
+// CaptureConsistencyToken returns the primary's @@GLOBAL.gtid_executed. Call it
+// after a write to read it back with PickServerAtLeast.
+func (b *Balancer) CaptureConsistencyToken(ctx context.Context) (ConsistencyToken, error) {
+	primary := b.PickWriter()
+	if primary == nil {
+		return "", ErrNoServerAvailable
+	}
+
+	connection := primary.GetConnection()
+	if connection == nil {
+		return "", fmt.Errorf("balancer: server %s is not connected", primary.name)
+	}
+
+	var gtidExecuted string
+	err := connection.Db.QueryRowContext(ctx, "SELECT @@GLOBAL.gtid_executed").Scan(&gtidExecuted)
+	if err != nil {
+		return "", fmt.Errorf("failed acquiring MySQL gtid_executed: %s", err)
+	}
+
+	return ConsistencyToken(gtidExecuted), nil
+}
+
+// PickServerAtLeast returns a replica, chosen by the strategy, that has
+// executed every transaction in token. When none has and
+// Config.ConsistencyWaitTimeout is set, it waits for the best replica to catch
+// up using WAIT_FOR_EXECUTED_GTID_SET. It falls back to the primary otherwise.
+func (b *Balancer) PickServerAtLeast(ctx context.Context, token ConsistencyToken) (*Server, error) {
+	if token == "" {
+		if server := b.PickServer(); server != nil {
+			return server, nil
+		}
+		return nil, ErrNoServerAvailable
+	}
+
+	candidates := b.eligibleServers()
+	strategy := b.strategy()
+
+	var best *Server
+	for len(candidates) > 0 {
+		server := strategy.Pick(candidates)
+		if best == nil {
+			best = server
+		}
+
+		if ok, err := server.hasExecutedGTIDSet(ctx, token); err == nil && ok {
+			return server, nil
+		}
+
+		candidates = candidates.without(server)
+	}
+
+	if best != nil && b.config.ConsistencyWaitTimeout > 0 {
+		if ok, err := best.waitForExecutedGTIDSet(ctx, token, b.config.ConsistencyWaitTimeout); err == nil && ok {
+			return best, nil
+		}
+	}
+
+	if primary := b.PickWriter(); primary != nil {
+		return primary, nil
+	}
+
+	return nil, ErrNoConsistentServer
+}
+
+// hasExecutedGTIDSet tells whether the server's gtid_executed contains token
+func (s *Server) hasExecutedGTIDSet(ctx context.Context, token ConsistencyToken) (bool, error) {
+	connection := s.GetConnection()
+	if connection == nil {
+		return false, fmt.Errorf("balancer: server %s is not connected", s.name)
+	}
+
+	var subset bool
+	err := connection.Db.QueryRowContext(ctx,
+		"SELECT GTID_SUBSET(?, @@GLOBAL.gtid_executed)", string(token),
+	).Scan(&subset)
+	if err != nil {
+		return false, err
+	}
+
+	return subset, nil
+}
+
+// waitForExecutedGTIDSet waits up to timeout, or the ctx deadline if sooner,
+// for the server to execute token
+func (s *Server) waitForExecutedGTIDSet(ctx context.Context, token ConsistencyToken, timeout time.Duration) (bool, error) {
+	connection := s.GetConnection()
+	if connection == nil {
+		return false, fmt.Errorf("balancer: server %s is not connected", s.name)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		timeout = time.Until(deadline)
+	}
+	if timeout <= 0 {
+		return false, context.DeadlineExceeded
+	}
+
+	var timedOut int
+	err := connection.Db.QueryRowContext(ctx,
+		"SELECT WAIT_FOR_EXECUTED_GTID_SET(?, ?)", string(token), timeout.Seconds(),
+	).Scan(&timedOut)
+	if err != nil {
+		return false, err
+	}
+
+	return timedOut == 0, nil
+}
@@ -0,0 +1,103 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCaptureConsistencyToken(t *testing.T) {
+	Convey("Given a balancer with a primary", t, func() {
+		primary, mock := getMockServer(t, "primary")
+		primary.serverSettings.Role = ServerRolePrimary
+		balancer := &Balancer{config: &Config{}, servers: []*Server{primary}}
+
+		Convey("It returns the primary gtid_executed", func() {
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT @@GLOBAL.gtid_executed")).WillReturnRows(
+				sqlmock.NewRows([]string{"gtid_executed"}).AddRow("uuid:1-10"))
+
+			token, err := balancer.CaptureConsistencyToken(context.Background())
+			So(err, ShouldBeNil)
+			So(token, ShouldEqual, ConsistencyToken("uuid:1-10"))
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+
+	Convey("Given a balancer without a primary", t, func() {
+		balancer := &Balancer{config: &Config{}, servers: []*Server{ServerUP}}
+
+		Convey("It fails with ErrNoServerAvailable", func() {
+			_, err := balancer.CaptureConsistencyToken(context.Background())
+			So(err, ShouldEqual, ErrNoServerAvailable)
+		})
+	})
+}
+
+func TestPickServerAtLeast(t *testing.T) {
+	Convey("Given a balancer with a primary and two replicas", t, func() {
+		primary, _ := getMockServer(t, "primary")
+		primary.serverSettings.Role = ServerRolePrimary
+		replica1, mock1 := getMockServer(t, "replica1")
+		replica2, mock2 := getMockServer(t, "replica2")
+		config := &Config{Strategy: NewRoundRobinStrategy()}
+		balancer := &Balancer{config: config, servers: []*Server{primary, replica1, replica2}}
+
+		token := ConsistencyToken("uuid:1-10")
+		subsetQuery := regexp.QuoteMeta("SELECT GTID_SUBSET(?, @@GLOBAL.gtid_executed)")
+		waitQuery := regexp.QuoteMeta("SELECT WAIT_FOR_EXECUTED_GTID_SET(?, ?)")
+
+		Convey("It returns a replica that executed the token", func() {
+			mock1.ExpectQuery(subsetQuery).WithArgs(string(token)).WillReturnRows(
+				sqlmock.NewRows([]string{"subset"}).AddRow(0))
+			mock2.ExpectQuery(subsetQuery).WithArgs(string(token)).WillReturnRows(
+				sqlmock.NewRows([]string{"subset"}).AddRow(1))
+
+			server, err := balancer.PickServerAtLeast(context.Background(), token)
+			So(err, ShouldBeNil)
+			So(server, ShouldPointTo, replica2)
+			So(mock1.ExpectationsWereMet(), ShouldBeNil)
+			So(mock2.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("It falls back to the primary when no replica is caught up", func() {
+			mock1.ExpectQuery(subsetQuery).WillReturnError(errors.New("fail"))
+			mock2.ExpectQuery(subsetQuery).WillReturnRows(sqlmock.NewRows([]string{"subset"}).AddRow(0))
+
+			server, err := balancer.PickServerAtLeast(context.Background(), token)
+			So(err, ShouldBeNil)
+			So(server, ShouldPointTo, primary)
+		})
+
+		Convey("It waits for the best replica when configured", func() {
+			config.ConsistencyWaitTimeout = time.Second
+			mock1.ExpectQuery(subsetQuery).WillReturnRows(sqlmock.NewRows([]string{"subset"}).AddRow(0))
+			mock2.ExpectQuery(subsetQuery).WillReturnRows(sqlmock.NewRows([]string{"subset"}).AddRow(0))
+			mock1.ExpectQuery(waitQuery).WithArgs(string(token), float64(1)).WillReturnRows(
+				sqlmock.NewRows([]string{"timeout"}).AddRow(0))
+
+			server, err := balancer.PickServerAtLeast(context.Background(), token)
+			So(err, ShouldBeNil)
+			So(server, ShouldPointTo, replica1)
+			So(mock1.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("It fails when neither replicas nor primary can serve the token", func() {
+			balancer.servers = []*Server{replica1}
+			mock1.ExpectQuery(subsetQuery).WillReturnRows(sqlmock.NewRows([]string{"subset"}).AddRow(0))
+
+			_, err := balancer.PickServerAtLeast(context.Background(), token)
+			So(err, ShouldEqual, ErrNoConsistentServer)
+		})
+
+		Convey("It behaves as PickServer without a token", func() {
+			server, err := balancer.PickServerAtLeast(context.Background(), "")
+			So(err, ShouldBeNil)
+			So(server, ShouldPointTo, replica1)
+		})
+	})
+}
@@ -1,14 +1,40 @@
 package balancer
 
 import (
+	"context"
+	"fmt"
 	"sync"
 	"time"
 )
 
 const (
 	WriteSetStateSync int = 4
+
+	GroupMemberStateOnline = "ONLINE"
+	GroupMemberRolePrimary = "PRIMARY"
 )
 
+// HealthCheckTimeoutError is the error of a server whose health check did not
+// complete within Config.HealthCheckTimeout
+type HealthCheckTimeoutError struct {
+	Server string
+	After  time.Duration
+}
+
+func (e *HealthCheckTimeoutError) Error() string {
+	return fmt.Sprintf("balancer: health check of %s timed out after %s", e.Server, e.After)
+}
+
+// Timeout reports the error as a timeout, as net.Error does
+func (e *HealthCheckTimeoutError) Timeout() bool {
+	return true
+}
+
+// Unwrap allows errors.Is(err, context.DeadlineExceeded)
+func (e *HealthCheckTimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
 // ServerHealth represents a Server health state
 type ServerHealth struct {
 	sync.Mutex
@@ -19,10 +45,30 @@ type ServerHealth struct {
 	wsrepReady bool
 	lastUpdate time.Time
 
-	secondsBehindMaster *int
-	openConnections     *int
-	runningConnections  *int
-	wsrepLocalState     *int
+	replicationLag     *time.Duration
+	openConnections    *int
+	runningConnections *int
+	wsrepLocalState    *int
+
+	groupMemberState string
+	groupMemberRole  string
+	applierQueue     *int
+
+	sqlRunning   bool
+	lastIOErrno  int
+	lastIOError  string
+	lastSQLErrno int
+	lastSQLError string
+	channels     []ChannelStatus
+
+	rise                 int
+	fall                 int
+	checked              bool
+	consecutiveSuccesses int
+	consecutiveFailures  int
+
+	probeLatency latencyEWMA
+	queryLatency latencyEWMA
 }
 
 // IsUP returns if the server is UP
@@ -32,14 +78,38 @@ func (h *ServerHealth) IsUP() bool {
 	return h.up
 }
 
+// GetConsecutiveSuccesses returns how many checks in a row found the server UP
+func (h *ServerHealth) GetConsecutiveSuccesses() int {
+	h.Lock()
+	defer h.Unlock()
+	return h.consecutiveSuccesses
+}
+
+// GetConsecutiveFailures returns how many checks in a row found the server
+// DOWN
+func (h *ServerHealth) GetConsecutiveFailures() int {
+	h.Lock()
+	defer h.Unlock()
+	return h.consecutiveFailures
+}
+
 // GetErr returns server's last error
 func (h *ServerHealth) GetErr() error {
 	return h.err
 }
 
-// GetSecondsBehindMaster returns server's seconds behind master
+// GetSecondsBehindMaster returns server's seconds behind master, rounded down
 func (h *ServerHealth) GetSecondsBehindMaster() *int {
-	return h.secondsBehindMaster
+	if h.replicationLag == nil {
+		return nil
+	}
+	seconds := int(*h.replicationLag / time.Second)
+	return &seconds
+}
+
+// GetReplicationLag returns server's replication lag
+func (h *ServerHealth) GetReplicationLag() *time.Duration {
+	return h.replicationLag
 }
 
 // GetWriteSetReplicationState returns server's wsrep_local_state
@@ -52,6 +122,27 @@ func (h *ServerHealth) GetWriteSetReady() bool {
 	return h.wsrepReady
 }
 
+// GetGroupMemberState returns server's MEMBER_STATE in the replication group
+func (h *ServerHealth) GetGroupMemberState() string {
+	return h.groupMemberState
+}
+
+// GetGroupMemberRole returns server's MEMBER_ROLE in the replication group
+func (h *ServerHealth) GetGroupMemberRole() string {
+	return h.groupMemberRole
+}
+
+// IsGroupPrimary returns if the server is the replication group's primary
+func (h *ServerHealth) IsGroupPrimary() bool {
+	return h.groupMemberRole == GroupMemberRolePrimary
+}
+
+// GetTransactionsInApplierQueue returns the number of transactions received
+// from the replication group waiting to be applied
+func (h *ServerHealth) GetTransactionsInApplierQueue() *int {
+	return h.applierQueue
+}
+
 // GetOpenConnections returns server's open connections
 func (h *ServerHealth) GetOpenConnections() *int {
 	return h.openConnections
@@ -67,24 +158,206 @@ func (h *ServerHealth) IORunning() bool {
 	return h.ioRunning
 }
 
-func (h *ServerHealth) setStatus(up, ioRunning, wsrepReady bool, err error, secondsBehindMaster, openConnections, runningConnections, wsrepLocalState *int) {
+// SQLRunning returns the SQL thread status from slave
+func (h *ServerHealth) SQLRunning() bool {
+	return h.sqlRunning
+}
+
+// GetLastIOErrno returns the number of the last error of the IO thread
+func (h *ServerHealth) GetLastIOErrno() int {
+	return h.lastIOErrno
+}
+
+// GetLastIOError returns the message of the last error of the IO thread
+func (h *ServerHealth) GetLastIOError() string {
+	return h.lastIOError
+}
+
+// GetLastSQLErrno returns the number of the last error of the SQL thread
+func (h *ServerHealth) GetLastSQLErrno() int {
+	return h.lastSQLErrno
+}
+
+// GetLastSQLError returns the message of the last error of the SQL thread
+func (h *ServerHealth) GetLastSQLError() string {
+	return h.lastSQLError
+}
+
+// GetChannels returns the status of each replication channel taken into
+// account, see ServerSettings.ReplicationChannels
+func (h *ServerHealth) GetChannels() []ChannelStatus {
+	return h.channels
+}
+
+// GetProbeLatency returns the moving average of the health check probe
+// latency
+func (h *ServerHealth) GetProbeLatency() *time.Duration {
 	h.Lock()
 	defer h.Unlock()
-	h.up = up
-	h.ioRunning = ioRunning
+	return h.probeLatency.getAverage()
+}
+
+// GetQueryLatency returns the moving average of the query latency reported by
+// clients, see Server.ReportLatency
+func (h *ServerHealth) GetQueryLatency() *time.Duration {
+	h.Lock()
+	defer h.Unlock()
+	return h.queryLatency.getAverage()
+}
+
+// GetLatency returns the query latency moving average, or the probe one when
+// no query latency was reported
+func (h *ServerHealth) GetLatency() *time.Duration {
+	h.Lock()
+	defer h.Unlock()
+	if h.queryLatency.samples > 0 {
+		return h.queryLatency.getAverage()
+	}
+	return h.probeLatency.getAverage()
+}
+
+// GetPeakLatency returns the peak moving average of the query latency, or of
+// the probe latency when no query latency was reported
+func (h *ServerHealth) GetPeakLatency() *time.Duration {
+	h.Lock()
+	defer h.Unlock()
+	if h.queryLatency.samples > 0 {
+		return h.queryLatency.getPeak()
+	}
+	return h.probeLatency.getPeak()
+}
+
+func (h *ServerHealth) observeProbeLatency(latency time.Duration) {
+	h.Lock()
+	defer h.Unlock()
+	h.probeLatency.observe(latency)
+}
+
+func (h *ServerHealth) observeQueryLatency(latency time.Duration) {
+	h.Lock()
+	defer h.Unlock()
+	h.queryLatency.observe(latency)
+}
+
+// setStatus records the result of a check. The server only goes UP after rise
+// consecutive successes and DOWN after fall consecutive failures, except on
+// the first check. Until then a failed check keeps the last known state.
+func (h *ServerHealth) setStatus(up, ioRunning, wsrepReady bool, err error, replicationLag *time.Duration, openConnections, runningConnections, wsrepLocalState *int) {
+	h.Lock()
+	defer h.Unlock()
+
+	if up {
+		h.consecutiveSuccesses++
+		h.consecutiveFailures = 0
+		if !h.checked || h.consecutiveSuccesses >= h.rise {
+			h.up = true
+		}
+	} else {
+		h.consecutiveFailures++
+		h.consecutiveSuccesses = 0
+		if !h.checked || h.consecutiveFailures >= h.fall {
+			h.up = false
+		}
+	}
+	h.checked = true
+
 	h.err = err
-	h.secondsBehindMaster = secondsBehindMaster
+	h.lastUpdate = time.Now()
+	if h.up && !up {
+		return
+	}
+
+	h.ioRunning = ioRunning
+	h.replicationLag = replicationLag
 	h.wsrepLocalState = wsrepLocalState
 	h.wsrepReady = wsrepReady
 	h.openConnections = openConnections
 	h.runningConnections = runningConnections
-	h.lastUpdate = time.Now()
 }
 
-func (h *ServerHealth) setUP(err error, ioRunning, wsrepReady bool, secondsBehindMaster, openConnections, runningConnections, wsrepLocalState *int) {
-	h.setStatus(true, ioRunning, wsrepReady, err, secondsBehindMaster, openConnections, runningConnections, wsrepLocalState)
+// copyStatus copies the state and the last check result of other
+func (h *ServerHealth) copyStatus(other *ServerHealth) {
+	other.Lock()
+	defer other.Unlock()
+	h.Lock()
+	defer h.Unlock()
+
+	h.up = other.up
+	h.err = other.err
+	h.ioRunning = other.ioRunning
+	h.wsrepReady = other.wsrepReady
+	h.lastUpdate = other.lastUpdate
+	h.replicationLag = other.replicationLag
+	h.openConnections = other.openConnections
+	h.runningConnections = other.runningConnections
+	h.wsrepLocalState = other.wsrepLocalState
+	h.groupMemberState = other.groupMemberState
+	h.groupMemberRole = other.groupMemberRole
+	h.applierQueue = other.applierQueue
+	h.sqlRunning = other.sqlRunning
+	h.lastIOErrno = other.lastIOErrno
+	h.lastIOError = other.lastIOError
+	h.lastSQLErrno = other.lastSQLErrno
+	h.lastSQLError = other.lastSQLError
+	h.channels = other.channels
+	h.checked = other.checked
+	h.consecutiveSuccesses = other.consecutiveSuccesses
+	h.consecutiveFailures = other.consecutiveFailures
+	h.probeLatency = other.probeLatency
+	h.queryLatency = other.queryLatency
+}
+
+// replicationStatus is the replication state read by a replica health check.
+// It is only recorded along with the check result, so the last known state is
+// kept while a check runs and when it fails.
+type replicationStatus struct {
+	// group replication
+	groupMemberState string
+	groupMemberRole  string
+	applierQueue     *int
+
+	// single source replication
+	channels []ChannelStatus
+	threads  ChannelStatus // the aggregate of channels
+}
+
+func (h *ServerHealth) setReplicationStatus(status *replicationStatus) {
+	h.setGroupReplicationStatus(status.groupMemberState, status.groupMemberRole, status.applierQueue)
+	h.setChannels(status.channels)
+	h.setReplicationThreadsStatus(
+		status.threads.SQLRunning, status.threads.LastIOErrno, status.threads.LastIOError,
+		status.threads.LastSQLErrno, status.threads.LastSQLError,
+	)
+}
+
+func (h *ServerHealth) setGroupReplicationStatus(memberState, memberRole string, applierQueue *int) {
+	h.Lock()
+	defer h.Unlock()
+	h.groupMemberState = memberState
+	h.groupMemberRole = memberRole
+	h.applierQueue = applierQueue
+}
+
+func (h *ServerHealth) setReplicationThreadsStatus(sqlRunning bool, lastIOErrno int, lastIOError string, lastSQLErrno int, lastSQLError string) {
+	h.Lock()
+	defer h.Unlock()
+	h.sqlRunning = sqlRunning
+	h.lastIOErrno = lastIOErrno
+	h.lastIOError = lastIOError
+	h.lastSQLErrno = lastSQLErrno
+	h.lastSQLError = lastSQLError
+}
+
+func (h *ServerHealth) setChannels(channels []ChannelStatus) {
+	h.Lock()
+	defer h.Unlock()
+	h.channels = channels
+}
+
+func (h *ServerHealth) setUP(err error, ioRunning, wsrepReady bool, replicationLag *time.Duration, openConnections, runningConnections, wsrepLocalState *int) {
+	h.setStatus(true, ioRunning, wsrepReady, err, replicationLag, openConnections, runningConnections, wsrepLocalState)
 }
 
-func (h *ServerHealth) setDown(err error, ioRunning, wsrepReady bool, secondsBehindMaster, openConnections, runningConnections, wsrepLocalState *int) {
-	h.setStatus(false, ioRunning, wsrepReady, err, secondsBehindMaster, openConnections, runningConnections, wsrepLocalState)
+func (h *ServerHealth) setDown(err error, ioRunning, wsrepReady bool, replicationLag *time.Duration, openConnections, runningConnections, wsrepLocalState *int) {
+	h.setStatus(false, ioRunning, wsrepReady, err, replicationLag, openConnections, runningConnections, wsrepLocalState)
 }
@@ -3,6 +3,7 @@ package balancer
 import (
 	"errors"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -12,13 +13,21 @@ func TestHealthAttributes(t *testing.T) {
 		expectedErr := errors.New("fail")
 
 		health := ServerHealth{
-			err:                 expectedErr,
-			openConnections:     &[]int{1}[0],
-			runningConnections:  &[]int{2}[0],
-			secondsBehindMaster: &[]int{3}[0],
-			wsrepLocalState:     &[]int{4}[0],
-			ioRunning:           true,
-			wsrepReady:          true,
+			err:                expectedErr,
+			openConnections:    &[]int{1}[0],
+			runningConnections: &[]int{2}[0],
+			replicationLag:     &[]time.Duration{3500 * time.Millisecond}[0],
+			wsrepLocalState:    &[]int{4}[0],
+			ioRunning:          true,
+			wsrepReady:         true,
+			groupMemberState:   GroupMemberStateOnline,
+			groupMemberRole:    GroupMemberRolePrimary,
+			applierQueue:       &[]int{5}[0],
+			sqlRunning:         true,
+			lastIOErrno:        2003,
+			lastIOError:        "error connecting to master",
+			lastSQLErrno:       1062,
+			lastSQLError:       "Duplicate entry",
 		}
 
 		Convey("It should return correct values", func() {
@@ -26,9 +35,87 @@ func TestHealthAttributes(t *testing.T) {
 			So(*health.GetOpenConnections(), ShouldEqual, 1)
 			So(*health.GetRunningConnections(), ShouldEqual, 2)
 			So(*health.GetSecondsBehindMaster(), ShouldEqual, 3)
+			So(*health.GetReplicationLag(), ShouldEqual, 3500*time.Millisecond)
 			So(*health.GetWriteSetReplicationState(), ShouldEqual, 4)
 			So(health.IORunning(), ShouldBeTrue)
 			So(health.GetWriteSetReady(), ShouldBeTrue)
+			So(health.GetGroupMemberState(), ShouldEqual, GroupMemberStateOnline)
+			So(health.GetGroupMemberRole(), ShouldEqual, GroupMemberRolePrimary)
+			So(health.IsGroupPrimary(), ShouldBeTrue)
+			So(*health.GetTransactionsInApplierQueue(), ShouldEqual, 5)
+			So(health.SQLRunning(), ShouldBeTrue)
+			So(health.GetLastIOErrno(), ShouldEqual, 2003)
+			So(health.GetLastIOError(), ShouldEqual, "error connecting to master")
+			So(health.GetLastSQLErrno(), ShouldEqual, 1062)
+			So(health.GetLastSQLError(), ShouldEqual, "Duplicate entry")
+		})
+	})
+}
+
+func TestHealthRiseAndFall(t *testing.T) {
+	Convey("Given a health requiring 2 successes to rise and 3 failures to fall", t, func() {
+		health := &ServerHealth{rise: 2, fall: 3}
+		lag := time.Second
+		connections := 1
+		checkErr := errors.New("connection refused")
+
+		Convey("The first check decides the state", func() {
+			health.setDown(checkErr, false, false, nil, nil, nil, nil)
+			So(health.IsUP(), ShouldBeFalse)
+			So(health.GetConsecutiveFailures(), ShouldEqual, 1)
+
+			Convey("It rises after 2 consecutive successes", func() {
+				health.setUP(nil, true, false, &lag, &connections, &connections, nil)
+				So(health.IsUP(), ShouldBeFalse)
+				So(health.GetConsecutiveSuccesses(), ShouldEqual, 1)
+				So(health.GetConsecutiveFailures(), ShouldEqual, 0)
+
+				health.setUP(nil, true, false, &lag, &connections, &connections, nil)
+				So(health.IsUP(), ShouldBeTrue)
+				So(health.GetConsecutiveSuccesses(), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When UP", func() {
+			health.setUP(nil, true, false, &lag, &connections, &connections, nil)
+			So(health.IsUP(), ShouldBeTrue)
+
+			Convey("It stays UP with its last known state until 3 consecutive failures", func() {
+				health.setDown(checkErr, false, false, nil, nil, nil, nil)
+				health.setDown(checkErr, false, false, nil, nil, nil, nil)
+				So(health.IsUP(), ShouldBeTrue)
+				So(health.GetErr(), ShouldEqual, checkErr)
+				So(health.GetConsecutiveFailures(), ShouldEqual, 2)
+				So(*health.GetReplicationLag(), ShouldEqual, lag)
+				So(health.IORunning(), ShouldBeTrue)
+
+				health.setDown(checkErr, false, false, nil, nil, nil, nil)
+				So(health.IsUP(), ShouldBeFalse)
+				So(health.GetConsecutiveFailures(), ShouldEqual, 3)
+				So(health.GetReplicationLag(), ShouldBeNil)
+			})
+
+			Convey("A success resets the failures", func() {
+				health.setDown(checkErr, false, false, nil, nil, nil, nil)
+				health.setDown(checkErr, false, false, nil, nil, nil, nil)
+				health.setUP(nil, true, false, &lag, &connections, &connections, nil)
+				health.setDown(checkErr, false, false, nil, nil, nil, nil)
+				So(health.IsUP(), ShouldBeTrue)
+				So(health.GetConsecutiveFailures(), ShouldEqual, 1)
+			})
+		})
+	})
+
+	Convey("Given a health with the default rise and fall", t, func() {
+		health := &ServerHealth{}
+
+		Convey("Every check decides the state", func() {
+			health.setUP(nil, true, false, nil, nil, nil, nil)
+			So(health.IsUP(), ShouldBeTrue)
+			health.setDown(errors.New("fail"), false, false, nil, nil, nil, nil)
+			So(health.IsUP(), ShouldBeFalse)
+			health.setUP(nil, true, false, nil, nil, nil, nil)
+			So(health.IsUP(), ShouldBeTrue)
 		})
 	})
 }
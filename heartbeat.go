@@ -0,0 +1,86 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// heartbeatLag measures the replication lag as the age of the newest row of
+// the server's heartbeat table, as written by pt-heartbeat or by the balancer
+// itself (see Config.HeartbeatInterval)
+func (s *Server) heartbeatLag(ctx context.Context, logger Logger) (time.Duration, error) {
+	heartbeatResult, err := s.rawQuery(
+		ctx, "SELECT TIMESTAMPDIFF(MICROSECOND, MAX(ts), NOW(6)) AS lag FROM "+s.heartbeatTable, logger,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed acquiring MySQL heartbeat from %s: %s", s.heartbeatTable, err)
+	}
+
+	rawLag := strings.TrimSpace(heartbeatResult["lag"])
+	if rawLag == "" || strings.ToLower(rawLag) == "null" {
+		return 0, fmt.Errorf("empty or null heartbeat returned from %s", s.heartbeatTable)
+	}
+
+	microseconds, err := strconv.ParseInt(rawLag, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected heartbeat returned from %s (conversion error): %s", s.heartbeatTable, err)
+	}
+
+	// clocks may be slightly skewed between primary and replica
+	if microseconds < 0 {
+		microseconds = 0
+	}
+
+	return time.Duration(microseconds) * time.Microsecond, nil
+}
+
+// writeHeartbeat updates the primary's row of the heartbeat table, giving up
+// when ctx is done or after Config.HeartbeatInterval, so a hung primary does
+// not delay the next heartbeats
+func (b *Balancer) writeHeartbeat(ctx context.Context) error {
+	primary := b.PickWriter()
+	if primary == nil {
+		return ErrNoServerAvailable
+	}
+
+	connection := primary.GetConnection()
+	if connection == nil {
+		return fmt.Errorf("balancer: server %s is not connected", primary.name)
+	}
+
+	if b.config.HeartbeatInterval > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.config.HeartbeatInterval)
+		defer cancel()
+	}
+
+	_, err := connection.Db.ExecContext(
+		ctx, "REPLACE INTO "+b.config.HeartbeatTable+" (ts, server_id) VALUES (NOW(6), @@server_id)",
+	)
+	return err
+}
+
+// startHeartbeat writes the heartbeat every Config.HeartbeatInterval until the
+// balancer is closed
+func (b *Balancer) startHeartbeat() {
+	ctx, cancel := context.WithCancel(context.Background())
+	b.stopHeartbeat = cancel
+
+	go func() {
+		ticker := time.NewTicker(b.config.HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := b.writeHeartbeat(ctx); err != nil && ctx.Err() == nil && b.logger != nil {
+					b.logger.Errorf("failed writing heartbeat: %s", err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,122 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHeartbeatLag(t *testing.T) {
+	Convey("Given a server with a heartbeat table", t, func() {
+		db, mock := getMock(t)
+		logger := newLoggerMock()
+		server := Server{replicationConnection: db, heartbeatTable: "percona.heartbeat"}
+		query := regexp.QuoteMeta("SELECT TIMESTAMPDIFF(MICROSECOND, MAX(ts), NOW(6)) AS lag FROM percona.heartbeat")
+
+		Convey("It returns the heartbeat age", func() {
+			mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(1500000))
+
+			lag, err := server.heartbeatLag(context.Background(), logger)
+			So(err, ShouldBeNil)
+			So(lag, ShouldEqual, 1500*time.Millisecond)
+		})
+
+		Convey("It ignores clock skew", func() {
+			mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(-300))
+
+			lag, err := server.heartbeatLag(context.Background(), logger)
+			So(err, ShouldBeNil)
+			So(lag, ShouldEqual, 0)
+		})
+
+		Convey("It fails on an empty heartbeat table", func() {
+			mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(nil))
+
+			_, err := server.heartbeatLag(context.Background(), logger)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "empty or null heartbeat")
+		})
+
+		Convey("It fails when the query fails", func() {
+			mock.ExpectQuery(query).WillReturnError(errors.New("fail"))
+
+			_, err := server.heartbeatLag(context.Background(), logger)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "failed acquiring MySQL heartbeat")
+		})
+	})
+}
+
+func TestCheckHealthWithHeartbeat(t *testing.T) {
+	Convey("Given a server with a heartbeat table", t, func() {
+		db, mock := getMock(t)
+		logger := newLoggerMock()
+		health := new(ServerHealth)
+		server := Server{
+			connection:            db,
+			replicationConnection: db,
+			health:                health,
+			heartbeatTable:        "percona.heartbeat",
+		}
+
+		Convey("When Seconds_Behind_Master is NULL", func() {
+			mockHealthQueries(t, mock, "ON", nil, 2, 1)
+			mock.ExpectQuery("SELECT TIMESTAMPDIFF").WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(2500000))
+
+			Convey("It should use the heartbeat lag", func() {
+				server.CheckHealth(false, logger)
+
+				So(health.up, ShouldBeTrue)
+				So(health.err, ShouldBeNil)
+				So(*health.replicationLag, ShouldEqual, 2500*time.Millisecond)
+				So(mock.ExpectationsWereMet(), ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestWriteHeartbeat(t *testing.T) {
+	Convey("Given a balancer with a primary", t, func() {
+		primary, mock := getMockServer(t, "primary")
+		primary.serverSettings.Role = ServerRolePrimary
+		balancer := &Balancer{
+			config:  &Config{HeartbeatTable: "percona.heartbeat"},
+			servers: []*Server{primary},
+		}
+
+		Convey("It writes the heartbeat on the primary", func() {
+			mock.ExpectExec(regexp.QuoteMeta(
+				"REPLACE INTO percona.heartbeat (ts, server_id) VALUES (NOW(6), @@server_id)",
+			)).WillReturnResult(sqlmock.NewResult(0, 1))
+
+			So(balancer.writeHeartbeat(context.Background()), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("It gives up after HeartbeatInterval when the primary hangs", func() {
+			balancer.config.HeartbeatInterval = 50 * time.Millisecond
+			mock.ExpectExec("REPLACE INTO percona.heartbeat").WillDelayFor(time.Minute).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			start := time.Now()
+			So(balancer.writeHeartbeat(context.Background()), ShouldNotBeNil)
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+		})
+	})
+
+	Convey("Given a balancer without a primary", t, func() {
+		balancer := &Balancer{
+			config:  &Config{HeartbeatTable: "percona.heartbeat"},
+			servers: []*Server{ServerUP},
+		}
+
+		Convey("It fails with ErrNoServerAvailable", func() {
+			So(balancer.writeHeartbeat(context.Background()), ShouldEqual, ErrNoServerAvailable)
+		})
+	})
+}
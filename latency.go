@@ -0,0 +1,136 @@
+package balancer
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// latencySmoothing is the weight of a new sample in the latency moving
+// averages
+const latencySmoothing = 0.2
+
+// latencyEWMA is an exponentially weighted moving average of latency samples.
+// The peak average jumps to any sample above it, so it reacts to latency
+// spikes right away and recovers smoothly.
+type latencyEWMA struct {
+	samples int
+	average float64
+	peak    float64
+}
+
+func (e *latencyEWMA) observe(sample time.Duration) {
+	value := float64(sample)
+	if e.samples == 0 {
+		e.average, e.peak = value, value
+	} else {
+		e.average += latencySmoothing * (value - e.average)
+		if value > e.peak {
+			e.peak = value
+		} else {
+			e.peak += latencySmoothing * (value - e.peak)
+		}
+	}
+	e.samples++
+}
+
+func (e *latencyEWMA) getAverage() *time.Duration {
+	if e.samples == 0 {
+		return nil
+	}
+	average := time.Duration(e.average)
+	return &average
+}
+
+func (e *latencyEWMA) getPeak() *time.Duration {
+	if e.samples == 0 {
+		return nil
+	}
+	peak := time.Duration(e.peak)
+	return &peak
+}
+
+// ReportLatency reports how long a query run on the server took, as seen by
+// the client. Connections returned by Connector report it themselves.
+func (s *Server) ReportLatency(latency time.Duration) {
+	s.health.observeQueryLatency(latency)
+}
+
+// trackQuery counts a query in flight on the server until the returned
+// function is called with its error, reporting its latency when successful
+func (s *Server) trackQuery() func(err error) {
+	start := time.Now()
+	atomic.AddInt64(&s.inFlight, 1)
+	return func(err error) {
+		atomic.AddInt64(&s.inFlight, -1)
+		if err == nil {
+			s.ReportLatency(time.Since(start))
+		}
+	}
+}
+
+// GetQueriesInFlight returns the number of queries running on the server
+// through Connector connections
+func (s *Server) GetQueriesInFlight() int64 {
+	return atomic.LoadInt64(&s.inFlight)
+}
+
+// byLatency sorts servers by latency, servers without any sample last
+type byLatency Servers
+
+func (a byLatency) Len() int      { return len(a) }
+func (a byLatency) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byLatency) Less(i, j int) bool {
+	latencyI, latencyJ := a[i].health.GetLatency(), a[j].health.GetLatency()
+	if latencyI == nil || latencyJ == nil {
+		return latencyI != nil
+	}
+	return *latencyI < *latencyJ
+}
+
+type leastLatencyStrategy struct{}
+
+// NewLeastLatencyStrategy returns a strategy that picks the server with the
+// lowest average latency (see ServerHealth.GetLatency), ignoring the
+// replication state
+func NewLeastLatencyStrategy() Strategy {
+	return leastLatencyStrategy{}
+}
+
+func (leastLatencyStrategy) Pick(candidates Servers) *Server {
+	sorted := make(Servers, len(candidates))
+	copy(sorted, candidates)
+	sort.Stable(byLatency(sorted))
+	return sorted[0]
+}
+
+type peakEWMAStrategy struct{}
+
+// NewPeakEWMAStrategy returns a strategy that picks the server with the lowest
+// peak latency (see ServerHealth.GetPeakLatency) weighted by its queries in
+// flight, as Finagle's peak EWMA load balancer. Servers without latency
+// samples are picked last.
+func NewPeakEWMAStrategy() Strategy {
+	return peakEWMAStrategy{}
+}
+
+func (peakEWMAStrategy) Pick(candidates Servers) *Server {
+	var best *Server
+	var bestCost float64
+	for _, server := range candidates {
+		peak := server.health.GetPeakLatency()
+		if peak == nil {
+			continue
+		}
+
+		cost := float64(*peak) * float64(server.GetQueriesInFlight()+1)
+		if best == nil || cost < bestCost {
+			best, bestCost = server, cost
+		}
+	}
+
+	if best == nil {
+		return candidates[0]
+	}
+	return best
+}
@@ -0,0 +1,100 @@
+package balancer
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLatencyEWMA(t *testing.T) {
+	Convey("Given a latency moving average", t, func() {
+		var ewma latencyEWMA
+
+		Convey("It is unknown without samples", func() {
+			So(ewma.getAverage(), ShouldBeNil)
+			So(ewma.getPeak(), ShouldBeNil)
+		})
+
+		Convey("The first sample sets it", func() {
+			ewma.observe(10 * time.Millisecond)
+			So(*ewma.getAverage(), ShouldEqual, 10*time.Millisecond)
+			So(*ewma.getPeak(), ShouldEqual, 10*time.Millisecond)
+
+			Convey("Later samples move it smoothly", func() {
+				ewma.observe(20 * time.Millisecond)
+				So(*ewma.getAverage(), ShouldEqual, 12*time.Millisecond)
+				So(*ewma.getPeak(), ShouldEqual, 20*time.Millisecond)
+
+				ewma.observe(10 * time.Millisecond)
+				So(*ewma.getAverage(), ShouldEqual, 11600*time.Microsecond)
+				So(*ewma.getPeak(), ShouldEqual, 18*time.Millisecond)
+			})
+		})
+	})
+}
+
+func TestLatencyStrategies(t *testing.T) {
+	Convey("Given servers with different latencies", t, func() {
+		newServer := func(name string, probeLatency time.Duration) *Server {
+			server := &Server{name: name, health: &ServerHealth{}}
+			server.health.observeProbeLatency(probeLatency)
+			return server
+		}
+
+		fast := newServer("fast", time.Millisecond)
+		slow := newServer("slow", 5*time.Millisecond)
+		unknown := &Server{name: "unknown", health: &ServerHealth{}}
+
+		Convey("The least latency strategy picks the fastest server", func() {
+			So(NewLeastLatencyStrategy().Pick(Servers{unknown, slow, fast}), ShouldPointTo, fast)
+		})
+
+		Convey("The least latency strategy prefers the query latency", func() {
+			fast.ReportLatency(10 * time.Millisecond)
+			So(*fast.health.GetLatency(), ShouldEqual, 10*time.Millisecond)
+			So(NewLeastLatencyStrategy().Pick(Servers{unknown, slow, fast}), ShouldPointTo, slow)
+		})
+
+		Convey("The peak EWMA strategy picks the lowest latency weighted by queries in flight", func() {
+			So(NewPeakEWMAStrategy().Pick(Servers{unknown, slow, fast}), ShouldPointTo, fast)
+
+			fast.inFlight = 5
+			So(NewPeakEWMAStrategy().Pick(Servers{unknown, slow, fast}), ShouldPointTo, slow)
+		})
+
+		Convey("The peak EWMA strategy reacts to latency spikes right away", func() {
+			fast.ReportLatency(time.Millisecond)
+			fast.ReportLatency(50 * time.Millisecond)
+			So(*fast.health.GetPeakLatency(), ShouldEqual, 50*time.Millisecond)
+			So(NewPeakEWMAStrategy().Pick(Servers{slow, fast}), ShouldPointTo, slow)
+		})
+
+		Convey("Without any latency known the first candidate is picked", func() {
+			other := &Server{name: "other", health: &ServerHealth{}}
+			So(NewPeakEWMAStrategy().Pick(Servers{unknown, other}), ShouldPointTo, unknown)
+			So(NewLeastLatencyStrategy().Pick(Servers{unknown, other}), ShouldPointTo, unknown)
+		})
+	})
+
+	Convey("Given a connector over a replica", t, func() {
+		server, mock := getMockServer(t, "replica")
+		balancer := &Balancer{config: &Config{}, servers: []*Server{server}}
+
+		db := sql.OpenDB(Connector(balancer))
+		defer db.Close()
+
+		Convey("Queries should report their latency", func() {
+			mock.ExpectExec("UPDATE counters").WillDelayFor(10 * time.Millisecond).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			_, err := db.Exec("UPDATE counters SET value = value + 1")
+			So(err, ShouldBeNil)
+			So(server.health.GetQueryLatency(), ShouldNotBeNil)
+			So(*server.health.GetQueryLatency(), ShouldBeGreaterThanOrEqualTo, 10*time.Millisecond)
+			So(server.GetQueriesInFlight(), ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,211 @@
+package balancer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ParseConfig parses a YAML or JSON document into a Config. Keys are the
+// snake_cased field names, with the server settings under servers, durations
+// are strings such as "1m30s" and enums are named, as replication_mode:
+// group_replication. Unknown keys are rejected. Logger and Strategy are left
+// for the caller to set.
+func ParseConfig(data []byte) (*Config, error) {
+	config := &Config{}
+	if err := decode(data, config); err != nil {
+		return nil, err
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// parseServersSettings parses the servers of a YAML or JSON document, listed
+// under servers as in a config file
+func parseServersSettings(data []byte) ([]ServerSettings, error) {
+	var document struct {
+		ServersSettings []ServerSettings `yaml:"servers"`
+	}
+	if err := decode(data, &document); err != nil {
+		return nil, err
+	}
+
+	if err := validateServersSettings("servers", document.ServersSettings).err(); err != nil {
+		return nil, err
+	}
+	return document.ServersSettings, nil
+}
+
+// decode decodes a YAML or JSON document into v, rejecting unknown keys and
+// empty documents
+func decode(data []byte, v interface{}) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(v); err != nil {
+		if err == io.EOF {
+			return errors.New("balancer: empty config")
+		}
+		return fmt.Errorf("balancer: invalid config: %s", err)
+	}
+	return nil
+}
+
+// LoadConfig reads and parses a YAML or JSON config file, see ParseConfig
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("balancer: could not read config: %s", err)
+	}
+	return ParseConfig(data)
+}
+
+// Reconcile applies config's servers and check interval to the running
+// balancer: servers missing from config are removed, new ones are added and
+// those whose settings changed are updated, see Balancer.UpdateServer. Servers
+// found by Config.Discoverers are left alone. The other settings only take
+// effect in a new balancer. An invalid config changes nothing.
+func (b *Balancer) Reconcile(config *Config) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+
+	desired := make(map[string]bool, len(config.ServersSettings))
+	for _, serverSettings := range config.ServersSettings {
+		desired[serverSettings.Name] = true
+	}
+
+	var errs []error
+	current := b.GetServers()
+	for _, server := range current {
+		if !desired[server.name] && server.discovery == nil {
+			errs = append(errs, b.RemoveServer(server.name))
+		}
+	}
+
+	for _, serverSettings := range config.ServersSettings {
+		server := current.byName(serverSettings.Name)
+		switch {
+		case server == nil:
+			errs = append(errs, b.AddServer(serverSettings))
+		case !reflect.DeepEqual(server.serverSettings, serverSettings):
+			errs = append(errs, b.UpdateServer(serverSettings))
+		}
+	}
+
+	checkInterval := config.CheckInterval
+	if checkInterval == 0 {
+		checkInterval = defaultCheckInterval
+	}
+	errs = append(errs, b.SetCheckInterval(checkInterval))
+
+	return errors.Join(errs...)
+}
+
+// ConfigWatcher reconciles a balancer with a config file when it changes
+type ConfigWatcher struct {
+	balancer *Balancer
+	path     string
+	checksum [sha256.Size]byte
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// WatchConfig reconciles b with the config file at path, see LoadConfig and
+// Balancer.Reconcile, then reads the file every interval and reconciles b again
+// whenever its content changes. Files that cannot be read or parsed are logged
+// and leave b untouched. Stop the watcher before closing b.
+func WatchConfig(b *Balancer, path string, interval time.Duration) (*ConfigWatcher, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("balancer: invalid watch interval %s", interval)
+	}
+
+	watcher := &ConfigWatcher{
+		balancer: b,
+		path:     path,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("balancer: could not read config: %s", err)
+	}
+	if err := watcher.reload(data); err != nil {
+		return nil, err
+	}
+
+	go watcher.watch(interval)
+	return watcher, nil
+}
+
+// Stop stops watching the file and waits for a running reload to finish
+func (w *ConfigWatcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+	<-w.done
+}
+
+func (w *ConfigWatcher) watch(interval time.Duration) {
+	defer close(w.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.check()
+		}
+	}
+}
+
+// check reloads the file when its content changed
+func (w *ConfigWatcher) check() {
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		w.logError(fmt.Errorf("balancer: could not read config: %s", err))
+		return
+	}
+
+	if sha256.Sum256(data) == w.checksum {
+		return
+	}
+
+	if err := w.reload(data); err != nil {
+		w.logError(err)
+	}
+}
+
+// reload reconciles the balancer with data. An invalid file is not reloaded
+// until it changes again.
+func (w *ConfigWatcher) reload(data []byte) error {
+	w.checksum = sha256.Sum256(data)
+
+	config, err := ParseConfig(data)
+	if err != nil {
+		return err
+	}
+	return w.balancer.Reconcile(config)
+}
+
+func (w *ConfigWatcher) logError(err error) {
+	if w.balancer.logger != nil {
+		w.balancer.logger.Errorf("%s: %s", w.path, err)
+	}
+}
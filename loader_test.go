@@ -0,0 +1,214 @@
+package balancer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const yamlConfig = `
+check_interval: 5
+startup_wait: 1m30s
+replication_mode: group_replication
+lag_fallback: primary
+health_check_timeout: 500ms
+servers:
+  - name: primary
+    dsn: user:pass@tcp(primary:3306)/db
+    role: primary
+  - name: replica1
+    dsn: user:pass@tcp(replica1:3306)/db
+    replication_dsn: repl:pass@tcp(replica1:3306)/
+    max_open_conns: 10
+    max_lifetime_conns: 5m
+    weight: 2
+    zone: us-east-1a
+    replication_channels: [a, b]
+`
+
+func TestParseConfig(t *testing.T) {
+	Convey("Given a YAML config", t, func() {
+		config, err := ParseConfig([]byte(yamlConfig))
+		So(err, ShouldBeNil)
+
+		Convey("It should parse the settings", func() {
+			So(config.CheckInterval, ShouldEqual, 5)
+			So(config.StartupWait, ShouldEqual, 90*time.Second)
+			So(config.ReplicationMode, ShouldEqual, ReplicationModeGroupReplication)
+			So(config.LagFallback, ShouldEqual, LagFallbackPrimary)
+			So(config.HealthCheckTimeout, ShouldEqual, 500*time.Millisecond)
+		})
+
+		Convey("It should parse the servers", func() {
+			So(config.ServersSettings, ShouldResemble, []ServerSettings{
+				{Name: "primary", DSN: "user:pass@tcp(primary:3306)/db", Role: ServerRolePrimary},
+				{
+					Name:                "replica1",
+					DSN:                 "user:pass@tcp(replica1:3306)/db",
+					ReplicationDSN:      "repl:pass@tcp(replica1:3306)/",
+					MaxOpenConns:        10,
+					MaxLifetimeConns:    5 * time.Minute,
+					Weight:              2,
+					Zone:                "us-east-1a",
+					ReplicationChannels: []string{"a", "b"},
+				},
+			})
+		})
+	})
+
+	Convey("Given a JSON config", t, func() {
+		config, err := ParseConfig([]byte(`{
+			"check_interval": 1,
+			"startup_wait": "2s",
+			"replication_mode": 1,
+			"servers": [{"name": "replica1", "dsn": "user@/db", "replication_dsn": "repl@/", "role": "replica"}]
+		}`))
+		So(err, ShouldBeNil)
+
+		Convey("It should parse it as well", func() {
+			So(config.CheckInterval, ShouldEqual, 1)
+			So(config.StartupWait, ShouldEqual, 2*time.Second)
+			So(config.ReplicationMode, ShouldEqual, ReplicationModeMultiSourceWriteSet)
+			So(config.ServersSettings, ShouldResemble, []ServerSettings{{Name: "replica1", DSN: testDSN, ReplicationDSN: testReplicationDSN}})
+		})
+	})
+
+	Convey("Given invalid configs", t, func() {
+		invalid := map[string]string{
+			"empty":              "",
+			"malformed":          "servers: [",
+			"unknown key":        "check_intervals: 3",
+			"numeric duration":   "startup_wait: 5",
+			"bad duration":       "startup_wait: soon",
+			"bad enum":           "replication_mode: async",
+			"enum out of range":  "lag_fallback: 3",
+			"negative interval":  "check_interval: -1",
+			"nameless server":    "servers: [{dsn: user@/db, replication_dsn: repl@/}]",
+			"server without dsn": "servers: [{name: replica1}]",
+			"duplicated server":  "servers: [{name: replica1, dsn: user@/db, replication_dsn: repl@/}, {name: replica1, dsn: user@/db, replication_dsn: repl@/}]",
+			"negative weight":    "servers: [{name: replica1, dsn: user@/db, replication_dsn: repl@/, weight: -1}]",
+		}
+
+		for name, data := range invalid {
+			Convey("It should reject "+name, func() {
+				config, err := ParseConfig([]byte(data))
+				So(err, ShouldNotBeNil)
+				So(config, ShouldBeNil)
+			})
+		}
+	})
+}
+
+func TestReconcile(t *testing.T) {
+	Convey("Given a balancer", t, func() {
+		replica1, _ := getMockServer(t, "replica1")
+		replica2, _ := getMockServer(t, "replica2")
+		replica1.serverSettings = ServerSettings{Name: "replica1", DSN: testDSN, ReplicationDSN: testReplicationDSN}
+		replica2.serverSettings = ServerSettings{Name: "replica2", DSN: testDSN, ReplicationDSN: testReplicationDSN}
+		balancer := &Balancer{config: &Config{}, servers: Servers{replica1, replica2}, checkInterval: 3}
+
+		Convey("It should add, update and remove servers", func() {
+			err := balancer.Reconcile(&Config{
+				CheckInterval: 10,
+				ServersSettings: []ServerSettings{
+					{Name: "replica1", DSN: testDSN, ReplicationDSN: testReplicationDSN},
+					{Name: "replica2", DSN: testDSN, ReplicationDSN: testReplicationDSN, Weight: 4},
+					{Name: "replica3", DSN: testDSN, ReplicationDSN: testReplicationDSN},
+				},
+			})
+			So(err, ShouldBeNil)
+
+			servers := balancer.GetServers()
+			So(servers, ShouldHaveLength, 3)
+			So(servers[0] == replica1, ShouldBeTrue)
+			So(servers[1] == replica2, ShouldBeFalse)
+			So(servers[1].GetWeight(), ShouldEqual, 4)
+			So(servers[2].GetName(), ShouldEqual, "replica3")
+			So(balancer.getCheckInterval(), ShouldEqual, 10*time.Second)
+
+			So(balancer.Reconcile(&Config{ServersSettings: []ServerSettings{{Name: "replica3", DSN: testDSN, ReplicationDSN: testReplicationDSN}}}), ShouldBeNil)
+			servers = balancer.GetServers()
+			So(servers, ShouldHaveLength, 1)
+			So(servers[0].GetName(), ShouldEqual, "replica3")
+			So(balancer.getCheckInterval(), ShouldEqual, defaultCheckInterval*time.Second)
+		})
+
+		Convey("It should not change anything with an invalid config", func() {
+			err := balancer.Reconcile(&Config{
+				CheckInterval:   10,
+				ServersSettings: []ServerSettings{{Name: "replica3"}},
+			})
+			So(err, ShouldNotBeNil)
+
+			servers := balancer.GetServers()
+			So(servers, ShouldHaveLength, 2)
+			So(servers[0] == replica1, ShouldBeTrue)
+			So(servers[1] == replica2, ShouldBeTrue)
+			So(balancer.getCheckInterval(), ShouldEqual, 3*time.Second)
+		})
+	})
+}
+
+func TestWatchConfig(t *testing.T) {
+	Convey("Given a balancer and a config file", t, func() {
+		path := filepath.Join(t.TempDir(), "balancer.yaml")
+		write := func(data string) {
+			So(os.WriteFile(path, []byte(data), 0o600), ShouldBeNil)
+		}
+		waitFor := func(condition func() bool) bool {
+			for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); {
+				if condition() {
+					return true
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			return false
+		}
+
+		write("servers: [{name: replica1, dsn: user@/db, replication_dsn: repl@/}]")
+		balancer := &Balancer{config: &Config{}, checkInterval: 3}
+
+		watcher, err := WatchConfig(balancer, path, 10*time.Millisecond)
+		So(err, ShouldBeNil)
+		defer watcher.Stop()
+
+		Convey("It should load the file right away", func() {
+			servers := balancer.GetServers()
+			So(servers, ShouldHaveLength, 1)
+			So(servers[0].GetName(), ShouldEqual, "replica1")
+		})
+
+		Convey("It should reload the file when it changes", func() {
+			write("servers: [{name: replica1, dsn: user@/db, replication_dsn: repl@/}, {name: replica2, dsn: user@/db, replication_dsn: repl@/}]")
+			So(waitFor(func() bool { return len(balancer.GetServers()) == 2 }), ShouldBeTrue)
+		})
+
+		Convey("It should ignore invalid files", func() {
+			servers := balancer.GetServers()
+			write("servers: [{name: replica2}]")
+			time.Sleep(50 * time.Millisecond)
+			So(balancer.GetServers(), ShouldHaveLength, 1)
+			So(balancer.GetServers()[0] == servers[0], ShouldBeTrue)
+
+			Convey("And reload it once fixed", func() {
+				write("servers: [{name: replica2, dsn: user@/db, replication_dsn: repl@/}]")
+				So(waitFor(func() bool {
+					servers := balancer.GetServers()
+					return len(servers) == 1 && servers[0].GetName() == "replica2"
+				}), ShouldBeTrue)
+			})
+		})
+
+		Convey("It should fail for missing or invalid files", func() {
+			_, err := WatchConfig(balancer, path+".missing", time.Second)
+			So(err, ShouldNotBeNil)
+
+			write("servers: [")
+			_, err = WatchConfig(balancer, path, time.Second)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,200 @@
+package balancer
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-gorp/gorp/v3"
+)
+
+const (
+	defaultDrainTimeout = 30 * time.Second
+	drainPollInterval   = 100 * time.Millisecond
+)
+
+// byName returns the server named name, if any
+func (s Servers) byName(name string) *Server {
+	for i := range s {
+		if s[i].name == name {
+			return s[i]
+		}
+	}
+	return nil
+}
+
+// AddServer adds a server to the balancer. It is picked once its first health
+// check, started right away, finds it UP. Invalid settings are rejected with
+// ConfigErrors, as NewWithError does.
+func (b *Balancer) AddServer(serverSettings ServerSettings) error {
+	if err := validateServerSettings("ServerSettings", serverSettings).err(); err != nil {
+		return err
+	}
+
+	server := b.newServer(serverSettings)
+	if err := b.addServer(server); err != nil {
+		return err
+	}
+
+	go server.CheckHealth(b.traceOn, b.logger)
+	return nil
+}
+
+// addServer adds server to the balancer, without checking its health
+func (b *Balancer) addServer(server *Server) error {
+	b.membershipLock.Lock()
+	defer b.membershipLock.Unlock()
+
+	b.serversLock.Lock()
+	if b.servers.byName(server.name) != nil {
+		b.serversLock.Unlock()
+		return fmt.Errorf("balancer: server %q already exists", server.name)
+	}
+	servers := make(Servers, len(b.servers), len(b.servers)+1)
+	copy(servers, b.servers)
+	b.servers = append(servers, server)
+	b.serversLock.Unlock()
+	return nil
+}
+
+// RemoveServer removes a server from the balancer. Its connections are closed
+// in the background once they are no longer in use, or after
+// Config.DrainTimeout.
+func (b *Balancer) RemoveServer(name string) error {
+	b.membershipLock.Lock()
+	defer b.membershipLock.Unlock()
+
+	b.serversLock.Lock()
+	server := b.servers.byName(name)
+	if server == nil {
+		b.serversLock.Unlock()
+		return fmt.Errorf("balancer: server %q not found", name)
+	}
+	b.servers = b.servers.without(server)
+	b.serversLock.Unlock()
+
+	go b.drain(server)
+	return nil
+}
+
+// UpdateServer replaces the server named serverSettings.Name by a new one
+// with the given settings, draining the former one as RemoveServer does. When
+// its DSNs and role are unchanged, the new server shares the connections of
+// the former one, which stay open, and keeps its last known health until the
+// next health check. Otherwise it is checked right away. Invalid settings are rejected as in
+// AddServer.
+func (b *Balancer) UpdateServer(serverSettings ServerSettings) error {
+	if err := validateServerSettings("ServerSettings", serverSettings).err(); err != nil {
+		return err
+	}
+
+	return b.updateServer(b.newServer(serverSettings))
+}
+
+func (b *Balancer) updateServer(server *Server) error {
+	b.membershipLock.Lock()
+	defer b.membershipLock.Unlock()
+
+	previous := b.GetServers().byName(server.name)
+	if previous == nil {
+		return fmt.Errorf("balancer: server %q not found", server.name)
+	}
+
+	server.discovery = previous.discovery
+	keepHealth := previous.serverSettings.DSN == server.serverSettings.DSN &&
+		previous.serverSettings.ReplicationDSN == server.serverSettings.ReplicationDSN &&
+		previous.serverSettings.Role == server.serverSettings.Role
+	if keepHealth {
+		server.shareConnections(previous)
+		server.health.copyStatus(previous.health)
+	}
+
+	b.serversLock.Lock()
+	servers := make(Servers, len(b.servers))
+	for i := range b.servers {
+		if b.servers[i] == previous {
+			servers[i] = server
+		} else {
+			servers[i] = b.servers[i]
+		}
+	}
+	b.servers = servers
+	b.serversLock.Unlock()
+
+	go b.drain(previous)
+	if !keepHealth {
+		go server.CheckHealth(b.traceOn, b.logger)
+	}
+	return nil
+}
+
+// drain closes a server removed from the balancer once no query runs on it
+// anymore, or after Config.DrainTimeout
+func (b *Balancer) drain(server *Server) {
+	server.markRemoved()
+
+	timeout := b.config.DrainTimeout
+	if timeout <= 0 {
+		timeout = defaultDrainTimeout
+	}
+
+	deadline := time.Now().Add(timeout)
+	for server.inUse() && time.Now().Before(deadline) {
+		time.Sleep(drainPollInterval)
+	}
+
+	server.Close()
+}
+
+// shareConnections makes s use the connections of previous, a server with the
+// same DSNs, applying the pool settings of s. previous keeps them, so callers
+// holding it can still query, but no longer owns them: it is marked removed,
+// so it is not checked anymore, and closing it leaves them open.
+func (s *Server) shareConnections(previous *Server) {
+	// wait for a running check, as Close does
+	previous.checkerLock.Lock()
+	defer previous.checkerLock.Unlock()
+	previous.markRemoved()
+
+	previous.connLock.Lock()
+	defer previous.connLock.Unlock()
+
+	s.connection = previous.connection
+	s.replicationConnection = previous.replicationConnection
+	s.version = previous.version
+	s.noGlobalStatusTable = previous.noGlobalStatusTable
+	previous.connectionsShared = true
+
+	for _, connection := range []*gorp.DbMap{s.connection, s.replicationConnection} {
+		if connection != nil && connection.Db != nil {
+			connection.Db.SetMaxIdleConns(s.serverSettings.MaxIdleConns)
+			connection.Db.SetMaxOpenConns(s.serverSettings.MaxOpenConns)
+			connection.Db.SetConnMaxLifetime(s.serverSettings.MaxLifetimeConns)
+		}
+	}
+}
+
+// markRemoved makes the connections opened by Connector invalid, so they are
+// no longer reused
+func (s *Server) markRemoved() {
+	atomic.StoreInt32(&s.removed, 1)
+}
+
+func (s *Server) isRemoved() bool {
+	return atomic.LoadInt32(&s.removed) == 1
+}
+
+// inUse tells whether queries are running on the server
+func (s *Server) inUse() bool {
+	if s.GetQueriesInFlight() > 0 {
+		return true
+	}
+
+	s.connLock.Lock()
+	defer s.connLock.Unlock()
+	// shared connections are used by the new server and are not closed
+	if s.connectionsShared {
+		return false
+	}
+	return s.connection != nil && s.connection.Db != nil && s.connection.Db.Stats().InUse > 0
+}
@@ -0,0 +1,220 @@
+package balancer
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAddServer(t *testing.T) {
+	Convey("Given a balancer", t, func() {
+		replica, _ := getMockServer(t, "replica")
+		balancer := &Balancer{config: &Config{}, servers: Servers{replica}}
+		before := balancer.GetServers()
+
+		Convey("It should add a server", func() {
+			So(balancer.AddServer(ServerSettings{Name: "new", DSN: testDSN, ReplicationDSN: testReplicationDSN, Weight: 3}), ShouldBeNil)
+
+			servers := balancer.GetServers()
+			So(servers, ShouldHaveLength, 2)
+			So(servers[1].GetName(), ShouldEqual, "new")
+			So(servers[1].GetWeight(), ShouldEqual, 3)
+			So(before, ShouldHaveLength, 1)
+		})
+
+		Convey("It should not pick the new server before it is UP", func() {
+			So(balancer.AddServer(ServerSettings{Name: "new", DSN: testDSN, ReplicationDSN: testReplicationDSN}), ShouldBeNil)
+			for i := 0; i < 10; i++ {
+				So(balancer.PickServer() == replica, ShouldBeTrue)
+			}
+		})
+
+		Convey("It should reject duplicated names", func() {
+			So(balancer.AddServer(ServerSettings{Name: "replica", DSN: testDSN, ReplicationDSN: testReplicationDSN}), ShouldNotBeNil)
+			So(balancer.GetServers(), ShouldHaveLength, 1)
+		})
+
+		Convey("It should reject invalid settings", func() {
+			err := balancer.AddServer(ServerSettings{})
+
+			var errs ConfigErrors
+			So(errors.As(err, &errs), ShouldBeTrue)
+			So(err.Error(), ShouldContainSubstring, "ServerSettings.Name: required")
+			So(err.Error(), ShouldContainSubstring, "ServerSettings.DSN: required")
+			So(balancer.GetServers(), ShouldHaveLength, 1)
+		})
+	})
+}
+
+func TestRemoveServer(t *testing.T) {
+	Convey("Given a balancer", t, func() {
+		replica1, _ := getMockServer(t, "replica1")
+		replica2, _ := getMockServer(t, "replica2")
+		balancer := &Balancer{config: &Config{}, servers: Servers{replica1, replica2}}
+
+		Convey("It should remove a server and stop picking it", func() {
+			So(balancer.RemoveServer("replica1"), ShouldBeNil)
+			servers := balancer.GetServers()
+			So(servers, ShouldHaveLength, 1)
+			So(servers[0] == replica2, ShouldBeTrue)
+			for i := 0; i < 10; i++ {
+				So(balancer.PickServer() == replica2, ShouldBeTrue)
+			}
+		})
+
+		Convey("It should fail for unknown servers", func() {
+			So(balancer.RemoveServer("unknown"), ShouldNotBeNil)
+			So(balancer.GetServers(), ShouldHaveLength, 2)
+		})
+
+		Convey("Draining should close the server", func() {
+			balancer.drain(replica1)
+			So(replica1.isRemoved(), ShouldBeTrue)
+			So(replica1.connection, ShouldBeNil)
+		})
+
+		Convey("Draining should wait for the running queries", func() {
+			atomic.AddInt64(&replica1.inFlight, 1)
+			time.AfterFunc(150*time.Millisecond, func() {
+				atomic.AddInt64(&replica1.inFlight, -1)
+			})
+
+			start := time.Now()
+			balancer.drain(replica1)
+			So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 150*time.Millisecond)
+			So(replica1.connection, ShouldBeNil)
+		})
+
+		Convey("Draining should give up after the drain timeout", func() {
+			balancer.config.DrainTimeout = 50 * time.Millisecond
+			atomic.AddInt64(&replica1.inFlight, 1)
+
+			start := time.Now()
+			balancer.drain(replica1)
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+			So(replica1.connection, ShouldBeNil)
+		})
+
+		Convey("Removed servers should not be checked anymore", func() {
+			balancer.drain(replica1)
+			replica1.CheckHealth(false, nil)
+			So(replica1.connection, ShouldBeNil)
+		})
+	})
+}
+
+func TestUpdateServer(t *testing.T) {
+	Convey("Given a balancer", t, func() {
+		replica, _ := getMockServer(t, "replica")
+		balancer := &Balancer{config: &Config{}, servers: Servers{replica}}
+
+		Convey("It should replace the server, keeping its health", func() {
+			settings := replica.serverSettings
+			settings.Weight = 4
+			settings.Zone = "us-east-1a"
+			So(balancer.UpdateServer(settings), ShouldBeNil)
+
+			updated := balancer.GetServers()[0]
+			So(updated == replica, ShouldBeFalse)
+			So(updated.GetWeight(), ShouldEqual, 4)
+			So(updated.GetZone(), ShouldEqual, "us-east-1a")
+			So(updated.health.IsUP(), ShouldBeTrue)
+			So(updated.GetConnection(), ShouldNotBeNil)
+			So(balancer.PickServer() == updated, ShouldBeTrue)
+		})
+
+		Convey("It should keep the connections open once the former server is drained", func() {
+			connection := replica.GetConnection()
+			settings := replica.serverSettings
+			settings.Weight = 2
+			settings.MaxIdleConns = 1
+			So(balancer.UpdateServer(settings), ShouldBeNil)
+
+			updated := balancer.GetServers()[0]
+			So(updated.GetConnection() == connection, ShouldBeTrue)
+			So(replica.isRemoved(), ShouldBeTrue)
+
+			balancer.drain(replica)
+			So(updated.GetConnection() == connection, ShouldBeTrue)
+			So(connection.Db.Ping(), ShouldBeNil)
+		})
+
+		Convey("It should keep the connections of a server picked before the update", func() {
+			picked := balancer.PickServer()
+			So(picked == replica, ShouldBeTrue)
+
+			settings := replica.serverSettings
+			settings.Weight = 3
+			settings.MaxIdleConns = 1
+			So(balancer.UpdateServer(settings), ShouldBeNil)
+
+			So(picked.GetConnection(), ShouldNotBeNil)
+			So(picked.GetConnection() == balancer.GetServers()[0].GetConnection(), ShouldBeTrue)
+
+			balancer.drain(picked)
+			So(picked.GetConnection(), ShouldNotBeNil)
+			So(picked.GetConnection().Db.Ping(), ShouldBeNil)
+		})
+
+		Convey("It should not keep the health of another DSN", func() {
+			settings := replica.serverSettings
+			settings.DSN = "user:password@tcp(10.0.0.2:3306)/database"
+			So(balancer.UpdateServer(settings), ShouldBeNil)
+			So(balancer.GetServers()[0].health.IsUP(), ShouldBeFalse)
+		})
+
+		Convey("It should reject invalid settings", func() {
+			settings := replica.serverSettings
+			settings.Weight = -1
+			So(balancer.UpdateServer(settings), ShouldNotBeNil)
+			So(balancer.GetServers()[0] == replica, ShouldBeTrue)
+		})
+
+		Convey("It should fail for unknown servers", func() {
+			So(balancer.UpdateServer(ServerSettings{Name: "unknown", DSN: testDSN, ReplicationDSN: testReplicationDSN}), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestServersConcurrentChanges(t *testing.T) {
+	Convey("Given a balancer picking servers", t, func() {
+		replica, _ := getMockServer(t, "replica")
+		balancer := &Balancer{config: &Config{DrainTimeout: time.Millisecond}, servers: Servers{replica}}
+
+		Convey("Servers can be changed concurrently", func() {
+			var wg sync.WaitGroup
+			stop := make(chan struct{})
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for {
+						select {
+						case <-stop:
+							return
+						default:
+							balancer.PickServer()
+							balancer.PickWriter()
+						}
+					}
+				}()
+			}
+
+			for i := 0; i < 20; i++ {
+				So(balancer.AddServer(ServerSettings{Name: "new", DSN: testDSN, ReplicationDSN: testReplicationDSN}), ShouldBeNil)
+				So(balancer.UpdateServer(ServerSettings{Name: "new", DSN: testDSN, ReplicationDSN: testReplicationDSN, Weight: 2}), ShouldBeNil)
+				So(balancer.RemoveServer("new"), ShouldBeNil)
+			}
+			close(stop)
+			wg.Wait()
+
+			servers := balancer.GetServers()
+			So(servers, ShouldHaveLength, 1)
+			So(servers[0] == replica, ShouldBeTrue)
+		})
+	})
+}
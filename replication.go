@@ -0,0 +1,141 @@
+package balancer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ChannelStatus is the replication status of a single replication channel
+// (a MariaDB connection)
+type ChannelStatus struct {
+	Name                string
+	IORunning           bool
+	SQLRunning          bool
+	SecondsBehindMaster *int
+	LastIOErrno         int
+	LastIOError         string
+	LastSQLErrno        int
+	LastSQLError        string
+}
+
+// replicationStatusColumns names the statement and columns of the
+// replication status in each MySQL/MariaDB flavor
+type replicationStatusColumns struct {
+	query      string
+	channel    string
+	ioRunning  string
+	sqlRunning string
+	lag        string
+}
+
+var (
+	slaveStatusColumns = replicationStatusColumns{
+		query:      "SHOW SLAVE STATUS",
+		channel:    "Channel_Name",
+		ioRunning:  "Slave_IO_Running",
+		sqlRunning: "Slave_SQL_Running",
+		lag:        "Seconds_Behind_Master",
+	}
+	replicaStatusColumns = replicationStatusColumns{
+		query:      "SHOW REPLICA STATUS",
+		channel:    "Channel_Name",
+		ioRunning:  "Replica_IO_Running",
+		sqlRunning: "Replica_SQL_Running",
+		lag:        "Seconds_Behind_Source",
+	}
+	mariaDBStatusColumns = replicationStatusColumns{
+		query:      "SHOW ALL SLAVES STATUS",
+		channel:    "Connection_name",
+		ioRunning:  "Slave_IO_Running",
+		sqlRunning: "Slave_SQL_Running",
+		lag:        "Seconds_Behind_Master",
+	}
+)
+
+// parseChannelStatus parses a row of the replication status. A NULL lag is
+// kept as nil.
+func parseChannelStatus(row map[string]string, columns replicationStatusColumns) (ChannelStatus, error) {
+	status := ChannelStatus{
+		Name:         row[columns.channel],
+		IORunning:    strings.EqualFold(row[columns.ioRunning], "Yes"),
+		SQLRunning:   strings.EqualFold(row[columns.sqlRunning], "Yes"),
+		LastIOError:  row["Last_IO_Error"],
+		LastSQLError: row["Last_SQL_Error"],
+	}
+	status.LastIOErrno, _ = strconv.Atoi(row["Last_IO_Errno"])
+	status.LastSQLErrno, _ = strconv.Atoi(row["Last_SQL_Errno"])
+
+	rawLag := strings.TrimSpace(row[columns.lag])
+	if rawLag == "" || strings.ToLower(rawLag) == "null" {
+		return status, nil
+	}
+
+	lag, err := strconv.Atoi(rawLag)
+	if err != nil {
+		return status, fmt.Errorf("unexpected value for %s returned from MySQL (conversion error): %s", columns.lag, err)
+	}
+	status.SecondsBehindMaster = &lag
+
+	return status, nil
+}
+
+// selectChannels returns the status of the named channels, or every channel
+// when names is empty
+func selectChannels(channels []ChannelStatus, names []string) ([]ChannelStatus, error) {
+	if len(names) == 0 {
+		return channels, nil
+	}
+
+	selected := make([]ChannelStatus, 0, len(names))
+	for _, name := range names {
+		found := false
+		for _, channel := range channels {
+			if strings.EqualFold(channel.Name, name) {
+				selected = append(selected, channel)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("replication channel %q not found", name)
+		}
+	}
+
+	return selected, nil
+}
+
+// aggregateChannels merges the status of several channels: threads are only
+// running if they run on every channel, the lag is the highest one (nil if
+// any is unknown) and errors are the first ones reported
+func aggregateChannels(channels []ChannelStatus) ChannelStatus {
+	aggregate := ChannelStatus{IORunning: true, SQLRunning: true}
+	lagKnown := true
+
+	for _, channel := range channels {
+		aggregate.IORunning = aggregate.IORunning && channel.IORunning
+		aggregate.SQLRunning = aggregate.SQLRunning && channel.SQLRunning
+
+		if channel.SecondsBehindMaster == nil {
+			lagKnown = false
+		} else if aggregate.SecondsBehindMaster == nil || *channel.SecondsBehindMaster > *aggregate.SecondsBehindMaster {
+			aggregate.SecondsBehindMaster = channel.SecondsBehindMaster
+		}
+
+		if aggregate.LastIOErrno == 0 && channel.LastIOErrno != 0 {
+			aggregate.LastIOErrno, aggregate.LastIOError = channel.LastIOErrno, channel.LastIOError
+		}
+		if aggregate.LastSQLErrno == 0 && channel.LastSQLErrno != 0 {
+			aggregate.LastSQLErrno, aggregate.LastSQLError = channel.LastSQLErrno, channel.LastSQLError
+		}
+	}
+
+	if !lagKnown || len(channels) == 0 {
+		aggregate.SecondsBehindMaster = nil
+	}
+	if len(channels) == 0 {
+		aggregate.IORunning, aggregate.SQLRunning = false, false
+	}
+
+	return aggregate
+}
@@ -0,0 +1,101 @@
+package balancer
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseChannelStatus(t *testing.T) {
+	Convey("When a replication status row is given", t, func() {
+		Convey("It parses the channel status", func() {
+			status, err := parseChannelStatus(map[string]string{
+				"Channel_Name":          "orders",
+				"Slave_IO_Running":      "Yes",
+				"Slave_SQL_Running":     "No",
+				"Seconds_Behind_Master": "12",
+				"Last_SQL_Errno":        "1062",
+				"Last_SQL_Error":        "Duplicate entry",
+			}, slaveStatusColumns)
+
+			So(err, ShouldBeNil)
+			So(status.Name, ShouldEqual, "orders")
+			So(status.IORunning, ShouldBeTrue)
+			So(status.SQLRunning, ShouldBeFalse)
+			So(*status.SecondsBehindMaster, ShouldEqual, 12)
+			So(status.LastSQLErrno, ShouldEqual, 1062)
+			So(status.LastSQLError, ShouldEqual, "Duplicate entry")
+		})
+
+		Convey("It keeps a NULL lag as nil", func() {
+			status, err := parseChannelStatus(map[string]string{"Seconds_Behind_Source": "NULL"}, replicaStatusColumns)
+			So(err, ShouldBeNil)
+			So(status.SecondsBehindMaster, ShouldBeNil)
+		})
+
+		Convey("It fails on unexpected lag values", func() {
+			_, err := parseChannelStatus(map[string]string{"Seconds_Behind_Master": "foo"}, mariaDBStatusColumns)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "unexpected value for Seconds_Behind_Master")
+		})
+	})
+}
+
+func TestSelectChannels(t *testing.T) {
+	Convey("When a list of channels are given", t, func() {
+		channels := []ChannelStatus{{Name: "orders"}, {Name: "users"}}
+
+		Convey("It keeps every channel when none is named", func() {
+			selected, err := selectChannels(channels, nil)
+			So(err, ShouldBeNil)
+			So(selected, ShouldHaveLength, 2)
+		})
+
+		Convey("It keeps the named channels", func() {
+			selected, err := selectChannels(channels, []string{"users"})
+			So(err, ShouldBeNil)
+			So(selected, ShouldHaveLength, 1)
+			So(selected[0].Name, ShouldEqual, "users")
+		})
+
+		Convey("It fails when a named channel is missing", func() {
+			_, err := selectChannels(channels, []string{"users", "payments"})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, `"payments"`)
+		})
+	})
+}
+
+func TestAggregateChannels(t *testing.T) {
+	Convey("When a list of channels are given", t, func() {
+		Convey("It uses the highest lag and requires every thread running", func() {
+			status := aggregateChannels([]ChannelStatus{
+				{IORunning: true, SQLRunning: true, SecondsBehindMaster: &[]int{1}[0]},
+				{IORunning: true, SQLRunning: false, SecondsBehindMaster: &[]int{5}[0], LastSQLErrno: 1062, LastSQLError: "Duplicate entry"},
+			})
+
+			So(status.IORunning, ShouldBeTrue)
+			So(status.SQLRunning, ShouldBeFalse)
+			So(*status.SecondsBehindMaster, ShouldEqual, 5)
+			So(status.LastSQLErrno, ShouldEqual, 1062)
+			So(status.LastSQLError, ShouldEqual, "Duplicate entry")
+		})
+
+		Convey("It has no lag when any channel lag is unknown", func() {
+			status := aggregateChannels([]ChannelStatus{
+				{IORunning: true, SQLRunning: true, SecondsBehindMaster: &[]int{1}[0]},
+				{IORunning: false, SQLRunning: true},
+			})
+
+			So(status.IORunning, ShouldBeFalse)
+			So(status.SecondsBehindMaster, ShouldBeNil)
+		})
+
+		Convey("It has nothing running without channels", func() {
+			status := aggregateChannels(nil)
+			So(status.IORunning, ShouldBeFalse)
+			So(status.SQLRunning, ShouldBeFalse)
+			So(status.SecondsBehindMaster, ShouldBeNil)
+		})
+	})
+}
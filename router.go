@@ -0,0 +1,307 @@
+package balancer
+
+import (
+	"context"
+	"database/sql/driver"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// QueryKind tells where a statement should be routed
+type QueryKind int
+
+const (
+	// QueryWrite statements go to the primary
+	QueryWrite QueryKind = iota
+	// QueryRead statements go to a replica
+	QueryRead
+)
+
+var (
+	routingHintRegexp = regexp.MustCompile(`(?i)/\*\s*balancer:(primary|replica)\s*\*/`)
+	lockingReadRegexp = regexp.MustCompile(`(?i)\bFOR\s+(UPDATE|SHARE)\b|\bLOCK\s+IN\s+SHARE\s+MODE\b`)
+	writeVerbRegexp   = regexp.MustCompile(`(?i)\b(INSERT|UPDATE|DELETE|REPLACE)\b`)
+)
+
+// ClassifyQuery tells whether query is a read or a write based on its leading
+// SQL verb. SELECT, SHOW, EXPLAIN, DESCRIBE and WITH (without data changes)
+// are reads, except locking reads such as SELECT ... FOR UPDATE. Everything
+// else is a write. The /* balancer:primary */ and /* balancer:replica */ hint
+// comments override the classification.
+func ClassifyQuery(query string) QueryKind {
+	if hint := routingHintRegexp.FindStringSubmatch(query); hint != nil {
+		if strings.EqualFold(hint[1], "replica") {
+			return QueryRead
+		}
+		return QueryWrite
+	}
+
+	switch leadingVerb(query) {
+	case "SELECT":
+		if lockingReadRegexp.MatchString(query) {
+			return QueryWrite
+		}
+		return QueryRead
+	case "WITH":
+		if lockingReadRegexp.MatchString(query) || writeVerbRegexp.MatchString(query) {
+			return QueryWrite
+		}
+		return QueryRead
+	case "SHOW", "EXPLAIN", "DESCRIBE", "DESC":
+		return QueryRead
+	default:
+		return QueryWrite
+	}
+}
+
+// leadingVerb returns the first keyword of query in upper case, skipping
+// whitespace, comments and opening parentheses
+func leadingVerb(query string) string {
+	for {
+		query = strings.TrimLeft(query, " \t\r\n(")
+		switch {
+		case strings.HasPrefix(query, "/*"):
+			end := strings.Index(query, "*/")
+			if end < 0 {
+				return ""
+			}
+			query = query[end+2:]
+		case strings.HasPrefix(query, "--"), strings.HasPrefix(query, "#"):
+			end := strings.IndexByte(query, '\n')
+			if end < 0 {
+				return ""
+			}
+			query = query[end+1:]
+		default:
+			end := strings.IndexFunc(query, func(r rune) bool {
+				return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+			})
+			if end < 0 {
+				end = len(query)
+			}
+			return strings.ToUpper(query[:end])
+		}
+	}
+}
+
+// PickServerFor returns PickServer for reads and PickWriter for writes,
+// according to ClassifyQuery
+func (b *Balancer) PickServerFor(query string) *Server {
+	if ClassifyQuery(query) == QueryRead {
+		return b.PickServer()
+	}
+	return b.PickWriter()
+}
+
+// SplitConnector returns a driver.Connector whose connections send reads to
+// a replica picked by PickServer and writes to the primary picked by
+// PickWriter, according to ClassifyQuery. Transactions are always run on the
+// primary. Each side is connected on first use. Session state (SET, temporary
+// tables, LAST_INSERT_ID) is not shared between both sides.
+func SplitConnector(b *Balancer) driver.Connector {
+	return &splitConnector{
+		read:  &connector{pick: b.PickServer},
+		write: &connector{pick: b.PickWriter},
+	}
+}
+
+type splitConnector struct {
+	read  *connector
+	write *connector
+}
+
+func (c *splitConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return &splitConn{connector: c}, nil
+}
+
+func (c *splitConnector) Driver() driver.Driver {
+	return connectorDriver{}
+}
+
+// splitConn routes each statement to its read or write connection
+type splitConn struct {
+	connector *splitConnector
+
+	lock  sync.Mutex
+	read  driver.Conn
+	write driver.Conn
+	inTx  bool
+}
+
+// conn returns the connection for kind, connecting it if needed. Inside a
+// transaction the write connection is always returned.
+func (c *splitConn) conn(ctx context.Context, kind QueryKind) (driver.Conn, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if kind == QueryRead && !c.inTx {
+		if c.read == nil {
+			conn, err := c.connector.read.Connect(ctx)
+			if err != nil {
+				return nil, err
+			}
+			c.read = conn
+		}
+		return c.read, nil
+	}
+
+	if c.write == nil {
+		conn, err := c.connector.write.Connect(ctx)
+		if err != nil {
+			return nil, err
+		}
+		c.write = conn
+	}
+	return c.write, nil
+}
+
+// opened returns the connections opened so far
+func (c *splitConn) opened() []driver.Conn {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	conns := make([]driver.Conn, 0, 2)
+	if c.read != nil {
+		conns = append(conns, c.read)
+	}
+	if c.write != nil {
+		conns = append(conns, c.write)
+	}
+	return conns
+}
+
+func (c *splitConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext implements driver.ConnPrepareContext
+func (c *splitConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	conn, err := c.conn(ctx, ClassifyQuery(query))
+	if err != nil {
+		return nil, err
+	}
+	return conn.(driver.ConnPrepareContext).PrepareContext(ctx, query)
+}
+
+// QueryContext implements driver.QueryerContext
+func (c *splitConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	conn, err := c.conn(ctx, ClassifyQuery(query))
+	if err != nil {
+		return nil, err
+	}
+	return conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+}
+
+// ExecContext implements driver.ExecerContext
+func (c *splitConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	conn, err := c.conn(ctx, ClassifyQuery(query))
+	if err != nil {
+		return nil, err
+	}
+	return conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+}
+
+func (c *splitConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx implements driver.ConnBeginTx, the transaction runs on the primary
+func (c *splitConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	conn, err := c.conn(ctx, QueryWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	c.lock.Lock()
+	c.inTx = true
+	c.lock.Unlock()
+
+	return &splitTx{Tx: tx, conn: c}, nil
+}
+
+// Ping implements driver.Pinger, pinging every opened connection or the read
+// one when none was opened yet
+func (c *splitConn) Ping(ctx context.Context) error {
+	conns := c.opened()
+	if len(conns) == 0 {
+		conn, err := c.conn(ctx, QueryRead)
+		if err != nil {
+			return err
+		}
+		conns = append(conns, conn)
+	}
+
+	for _, conn := range conns {
+		if err := conn.(driver.Pinger).Ping(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsValid implements driver.Validator
+func (c *splitConn) IsValid() bool {
+	for _, conn := range c.opened() {
+		if !conn.(driver.Validator).IsValid() {
+			return false
+		}
+	}
+	return true
+}
+
+// ResetSession implements driver.SessionResetter
+func (c *splitConn) ResetSession(ctx context.Context) error {
+	for _, conn := range c.opened() {
+		if err := conn.(driver.SessionResetter).ResetSession(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckNamedValue implements driver.NamedValueChecker
+func (c *splitConn) CheckNamedValue(value *driver.NamedValue) error {
+	conns := c.opened()
+	if len(conns) == 0 {
+		return driver.ErrSkip
+	}
+	return conns[0].(driver.NamedValueChecker).CheckNamedValue(value)
+}
+
+func (c *splitConn) Close() error {
+	var firstErr error
+	for _, conn := range c.opened() {
+		if err := conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// splitTx releases the write pinning once the transaction ends
+type splitTx struct {
+	driver.Tx
+	conn *splitConn
+}
+
+func (t *splitTx) Commit() error {
+	defer t.done()
+	return t.Tx.Commit()
+}
+
+func (t *splitTx) Rollback() error {
+	defer t.done()
+	return t.Tx.Rollback()
+}
+
+func (t *splitTx) done() {
+	t.conn.lock.Lock()
+	t.conn.inTx = false
+	t.conn.lock.Unlock()
+}
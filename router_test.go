@@ -0,0 +1,129 @@
+package balancer
+
+import (
+	"database/sql"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestClassifyQuery(t *testing.T) {
+	Convey("When queries are given", t, func() {
+		Convey("It classifies reads", func() {
+			for _, query := range []string{
+				"SELECT 1",
+				"  select * from users",
+				"(SELECT 1) UNION (SELECT 2)",
+				"/* comment */ SELECT 1",
+				"-- comment\nSELECT 1",
+				"# comment\nSELECT 1",
+				"SHOW TABLES",
+				"EXPLAIN SELECT 1",
+				"DESCRIBE users",
+				"WITH cte AS (SELECT 1) SELECT * FROM cte",
+				"/* balancer:replica */ INSERT INTO users VALUES (1)",
+			} {
+				So(ClassifyQuery(query), ShouldEqual, QueryRead)
+			}
+		})
+
+		Convey("It classifies writes", func() {
+			for _, query := range []string{
+				"INSERT INTO users VALUES (1)",
+				"update users set name = 'foo'",
+				"DELETE FROM users",
+				"REPLACE INTO users VALUES (1)",
+				"CREATE TABLE foo (id INT)",
+				"SET NAMES utf8",
+				"SELECT * FROM users WHERE id = 1 FOR UPDATE",
+				"SELECT * FROM users WHERE id = 1 FOR SHARE",
+				"SELECT * FROM users WHERE id = 1 LOCK IN SHARE MODE",
+				"WITH cte AS (SELECT 1) UPDATE users JOIN cte SET name = 'foo'",
+				"SELECT /* balancer:primary */ * FROM users",
+				"/* unterminated",
+				"",
+			} {
+				So(ClassifyQuery(query), ShouldEqual, QueryWrite)
+			}
+		})
+	})
+}
+
+func TestPickServerFor(t *testing.T) {
+	Convey("Given a balancer with a primary and a replica", t, func() {
+		primary := &Server{
+			name:           "primary",
+			health:         &ServerHealth{},
+			serverSettings: ServerSettings{Role: ServerRolePrimary},
+		}
+		primary.health.setUP(nil, false, false, nil, nil, nil, nil)
+		balancer := &Balancer{config: &Config{}, servers: []*Server{primary, ServerUP}}
+
+		Convey("It routes each query to its server", func() {
+			So(balancer.PickServerFor("SELECT 1"), ShouldPointTo, ServerUP)
+			So(balancer.PickServerFor("DELETE FROM users"), ShouldPointTo, primary)
+		})
+	})
+}
+
+func TestSplitConnector(t *testing.T) {
+	Convey("Given a balancer with a primary and a replica", t, func() {
+		primary, primaryMock := getMockServer(t, "primary")
+		primary.serverSettings.Role = ServerRolePrimary
+		replica, replicaMock := getMockServer(t, "replica")
+		balancer := &Balancer{config: &Config{}, servers: []*Server{primary, replica}}
+
+		db := sql.OpenDB(SplitConnector(balancer))
+		db.SetMaxOpenConns(1)
+		defer db.Close()
+
+		Convey("It sends reads to the replica and writes to the primary", func() {
+			replicaMock.ExpectQuery("SELECT name FROM users").WillReturnRows(
+				sqlmock.NewRows([]string{"name"}).AddRow("foo"))
+			primaryMock.ExpectExec("UPDATE users").WillReturnResult(sqlmock.NewResult(0, 1))
+
+			var name string
+			So(db.QueryRow("SELECT name FROM users").Scan(&name), ShouldBeNil)
+			So(name, ShouldEqual, "foo")
+
+			_, err := db.Exec("UPDATE users SET name = 'bar'")
+			So(err, ShouldBeNil)
+
+			So(replicaMock.ExpectationsWereMet(), ShouldBeNil)
+			So(primaryMock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("It keeps transactions on the primary", func() {
+			primaryMock.ExpectBegin()
+			primaryMock.ExpectQuery("SELECT name FROM users").WillReturnRows(
+				sqlmock.NewRows([]string{"name"}).AddRow("foo"))
+			primaryMock.ExpectCommit()
+			replicaMock.ExpectQuery("SELECT name FROM users").WillReturnRows(
+				sqlmock.NewRows([]string{"name"}).AddRow("bar"))
+
+			tx, err := db.Begin()
+			So(err, ShouldBeNil)
+
+			var name string
+			So(tx.QueryRow("SELECT name FROM users").Scan(&name), ShouldBeNil)
+			So(name, ShouldEqual, "foo")
+			So(tx.Commit(), ShouldBeNil)
+
+			So(db.QueryRow("SELECT name FROM users").Scan(&name), ShouldBeNil)
+			So(name, ShouldEqual, "bar")
+
+			So(replicaMock.ExpectationsWereMet(), ShouldBeNil)
+			So(primaryMock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("It honors routing hints", func() {
+			primaryMock.ExpectQuery("SELECT name FROM users").WillReturnRows(
+				sqlmock.NewRows([]string{"name"}).AddRow("foo"))
+
+			var name string
+			So(db.QueryRow("/* balancer:primary */ SELECT name FROM users").Scan(&name), ShouldBeNil)
+			So(primaryMock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}
@@ -1,12 +1,14 @@
 package balancer
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/go-gorp/gorp/v3"
 )
@@ -21,6 +23,18 @@ type Server struct {
 	traceOn               bool
 	isChecking            int32
 	replicationMode       ReplicationMode
+	version               *serverVersion
+	heartbeatTable        string
+	healthCheckTimeout    time.Duration
+	noGlobalStatusTable   bool
+	checkStart            time.Time
+	breaker               circuitBreaker
+	recheck               func()
+	inFlight              int64
+	weight                int64
+	removed               int32
+	discovery             *discovery // the Discoverer that added the server, if any
+	connectionsShared     bool       // the connections belong to the server that replaced it
 	connLock              sync.Mutex
 	checkerLock           sync.Mutex
 }
@@ -32,6 +46,10 @@ func (s *Server) Close() {
 	s.connLock.Lock()
 	defer s.connLock.Unlock()
 
+	if s.connectionsShared {
+		return
+	}
+
 	if s.connection != nil && s.connection.Db != nil {
 		s.connection.Db.Close()
 		s.connection = nil
@@ -48,6 +66,38 @@ func (s *Server) GetName() string {
 	return s.name
 }
 
+// GetRole returns server's role
+func (s *Server) GetRole() ServerRole {
+	return s.serverSettings.Role
+}
+
+// IsPrimary returns if the server is a writer
+func (s *Server) IsPrimary() bool {
+	return s.serverSettings.Role == ServerRolePrimary
+}
+
+// GetZone returns server's availability zone
+func (s *Server) GetZone() string {
+	return s.serverSettings.Zone
+}
+
+// GetRegion returns server's region
+func (s *Server) GetRegion() string {
+	return s.serverSettings.Region
+}
+
+// GetWeight returns server's weight, see ServerSettings.Weight
+func (s *Server) GetWeight() int {
+	if weight := atomic.LoadInt64(&s.weight); weight > 0 {
+		return int(weight)
+	}
+	return 1
+}
+
+func (s *Server) setWeight(weight int) {
+	atomic.StoreInt64(&s.weight, int64(weight))
+}
+
 // GetHealth returns server's health state
 func (s *Server) GetHealth() *ServerHealth {
 	return s.health
@@ -58,7 +108,7 @@ func (s *Server) GetConnection() *gorp.DbMap {
 	return s.connection
 }
 
-func (s *Server) connect(dsn string, traceOn bool, logger Logger) (*gorp.DbMap, error) {
+func (s *Server) connect(ctx context.Context, dsn string, traceOn bool, logger Logger) (*gorp.DbMap, error) {
 	conn, err := sql.Open("mysql", dsn)
 	if err != nil {
 		return nil, err
@@ -68,7 +118,7 @@ func (s *Server) connect(dsn string, traceOn bool, logger Logger) (*gorp.DbMap,
 	conn.SetMaxOpenConns(s.serverSettings.MaxOpenConns)
 	conn.SetConnMaxLifetime(s.serverSettings.MaxLifetimeConns)
 
-	if err := conn.Ping(); err != nil {
+	if err := conn.PingContext(ctx); err != nil {
 		conn.Close()
 		return nil, err
 	}
@@ -87,10 +137,20 @@ func (s *Server) connect(dsn string, traceOn bool, logger Logger) (*gorp.DbMap,
 
 // CheckHealth check server's health and set it's state
 func (s *Server) CheckHealth(traceOn bool, logger Logger) {
+	s.CheckHealthContext(context.Background(), traceOn, logger)
+}
+
+// CheckHealthContext checks server's health and sets its state, giving up
+// when ctx is done or after Config.HealthCheckTimeout. A check that times out
+// sets the server DOWN with a *HealthCheckTimeoutError.
+func (s *Server) CheckHealthContext(ctx context.Context, traceOn bool, logger Logger) {
 	s.checkerLock.Lock()
 	defer s.checkerLock.Unlock()
 
-	var secondsBehindMaster, openConnections, runningConnections, wsrepLocalState *int
+	// removed servers are closed, checking them would connect them again
+	if s.isRemoved() {
+		return
+	}
 
 	// prevent concurrently checks on same server (slow queries/network)
 	if atomic.LoadInt32(&s.isChecking) == 1 {
@@ -102,74 +162,151 @@ func (s *Server) CheckHealth(traceOn bool, logger Logger) {
 		atomic.StoreInt32(&s.isChecking, 0)
 	}()
 
-	if err := s.connectReadUser(traceOn, logger); err != nil {
-		s.health.setDown(
-			err, false, false, secondsBehindMaster, openConnections, runningConnections, wsrepLocalState,
+	s.checkStart = time.Now()
+	if s.healthCheckTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.healthCheckTimeout)
+		defer cancel()
+	}
+
+	if s.IsPrimary() {
+		s.checkPrimaryHealth(ctx, traceOn, logger)
+	} else {
+		s.checkReplicaHealth(ctx, traceOn, logger)
+	}
+}
+
+// setHealthUP records the result of a check that found the server UP, along
+// with the replication status it read if any, unless the check timed out
+func (s *Server) setHealthUP(ctx context.Context, err error, ioRunning, wsrepReady bool, replicationLag *time.Duration, openConnections, runningConnections, wsrepLocalState *int, replication *replicationStatus) {
+	if ctx.Err() == context.DeadlineExceeded {
+		s.setHealthTimedOut()
+		return
+	}
+	if replication != nil {
+		s.health.setReplicationStatus(replication)
+	}
+	s.health.setUP(err, ioRunning, wsrepReady, replicationLag, openConnections, runningConnections, wsrepLocalState)
+}
+
+// setHealthDown records the result of a check that found the server DOWN
+func (s *Server) setHealthDown(ctx context.Context, err error, ioRunning, wsrepReady bool, replicationLag *time.Duration, openConnections, runningConnections, wsrepLocalState *int) {
+	if ctx.Err() == context.DeadlineExceeded {
+		s.setHealthTimedOut()
+		return
+	}
+	s.health.setDown(err, ioRunning, wsrepReady, replicationLag, openConnections, runningConnections, wsrepLocalState)
+}
+
+func (s *Server) setHealthTimedOut() {
+	var nilHelper *int
+	s.health.setDown(
+		&HealthCheckTimeoutError{Server: s.name, After: time.Since(s.checkStart).Round(time.Millisecond)},
+		false, false, nil, nilHelper, nilHelper, nilHelper,
+	)
+}
+
+// checkReplicaHealth sets a replica state from its replication status
+func (s *Server) checkReplicaHealth(ctx context.Context, traceOn bool, logger Logger) {
+	var replicationLag *time.Duration
+	var openConnections, runningConnections, wsrepLocalState *int
+	var replication *replicationStatus
+
+	if err := s.connectReadUser(ctx, traceOn, logger); err != nil {
+		s.setHealthDown(
+			ctx, err, false, false, replicationLag, openConnections, runningConnections, wsrepLocalState,
 		)
 		return
 	}
 
-	if err := s.connectReplicationUser(traceOn, logger); err != nil {
-		s.health.setUP(
-			err, false, false, secondsBehindMaster, openConnections, runningConnections, wsrepLocalState,
+	if err := s.connectReplicationUser(ctx, traceOn, logger); err != nil {
+		s.setHealthUP(
+			ctx, err, false, false, replicationLag, openConnections, runningConnections, wsrepLocalState, replication,
 		)
 		return
 	}
 
+	if s.replicationMode == ReplicationModeSingleSource && s.version == nil {
+		s.detectVersion(ctx, logger)
+	}
+
+	statusNames := []string{"Threads_connected", "Threads_running"}
+	switch s.replicationMode {
+	case ReplicationModeSingleSource:
+		if s.hasSlaveRunningStatus() {
+			statusNames = append(statusNames, "Slave_running")
+		}
+	case ReplicationModeMultiSourceWriteSet:
+		statusNames = append(statusNames, "wsrep_connected", "wsrep_ready", "wsrep_local_state")
+	}
+	probeStart := time.Now()
+	status, statusErr := s.globalStatus(ctx, statusNames, logger)
+	if statusErr == nil {
+		s.health.observeProbeLatency(time.Since(probeStart))
+	}
+
 	ioRunning := false
 	wsrepReady := false
 	if s.replicationMode == ReplicationModeSingleSource {
-		ioRunningResult, err := s.rawQuery("SHOW STATUS LIKE 'Slave_running'", logger)
-		if err == nil && strings.EqualFold(ioRunningResult["Value"], "ON") {
-			ioRunning = true
-		}
+		ioRunning = strings.EqualFold(status["Slave_running"], "ON")
 	} else if s.replicationMode == ReplicationModeMultiSourceWriteSet {
-		ioRunningResult, err := s.rawQuery("SHOW STATUS LIKE 'wsrep_connected'", logger)
-		if err == nil && strings.EqualFold(ioRunningResult["Value"], "ON") {
-			ioRunning = true
+		ioRunning = strings.EqualFold(status["wsrep_connected"], "ON")
+		wsrepReady = strings.EqualFold(status["wsrep_ready"], "ON")
+	} else if s.replicationMode == ReplicationModeGroupReplication {
+		memberResult, err := s.rawQuery(
+			ctx, "SELECT MEMBER_STATE, MEMBER_ROLE FROM performance_schema.replication_group_members "+
+				"WHERE MEMBER_ID = @@server_uuid", logger,
+		)
+		// a server out of the group has no member row
+		if err != nil && err != sql.ErrNoRows {
+			s.setHealthUP(
+				ctx, fmt.Errorf("failed acquiring MySQL group replication member state: %s", err),
+				ioRunning, wsrepReady, replicationLag, openConnections, runningConnections, wsrepLocalState, replication,
+			)
+			return
 		}
-		readyResult, err := s.rawQuery("SHOW STATUS LIKE 'wsrep_ready'", logger)
-		if err == nil && strings.EqualFold(readyResult["Value"], "ON") {
-			wsrepReady = true
+
+		replication = &replicationStatus{
+			groupMemberState: strings.ToUpper(memberResult["MEMBER_STATE"]),
+			groupMemberRole:  strings.ToUpper(memberResult["MEMBER_ROLE"]),
 		}
+		ioRunning = replication.groupMemberState == GroupMemberStateOnline
 	}
 
-	threadsConnectedResult, err := s.rawQuery("SHOW STATUS LIKE 'Threads_connected'", logger)
-	if err != nil {
-		s.health.setUP(
-			fmt.Errorf("failed acquiring MySQL thread connected status:  %s", err),
-			ioRunning, wsrepReady, secondsBehindMaster, openConnections, runningConnections, wsrepLocalState,
+	threadsConnected, ok := status["Threads_connected"]
+	if !ok {
+		s.setHealthUP(
+			ctx, fmt.Errorf("failed acquiring MySQL thread connected status:  %s", statusErr),
+			ioRunning, wsrepReady, replicationLag, openConnections, runningConnections, wsrepLocalState, replication,
 		)
 		return
 	}
 
-	threadsConnected := threadsConnectedResult["Value"]
 	tmp2, err := strconv.Atoi(threadsConnected)
 	if err != nil {
-		s.health.setUP(
-			fmt.Errorf("unexpected value for Threads_connected returned from MySQL:  %s", err),
-			ioRunning, wsrepReady, secondsBehindMaster, openConnections, runningConnections, wsrepLocalState,
+		s.setHealthUP(
+			ctx, fmt.Errorf("unexpected value for Threads_connected returned from MySQL:  %s", err),
+			ioRunning, wsrepReady, replicationLag, openConnections, runningConnections, wsrepLocalState, replication,
 		)
 		return
 	}
 
 	openConnections = &tmp2
 
-	threadsRunningResult, err := s.rawQuery("SHOW STATUS LIKE 'Threads_running'", logger)
-	if err != nil {
-		s.health.setUP(
-			fmt.Errorf("failed acquiring MySQL thread running status:  %s", err),
-			ioRunning, wsrepReady, secondsBehindMaster, openConnections, runningConnections, wsrepLocalState,
+	threadsRunning, ok := status["Threads_running"]
+	if !ok {
+		s.setHealthUP(
+			ctx, fmt.Errorf("failed acquiring MySQL thread running status:  %s", statusErr),
+			ioRunning, wsrepReady, replicationLag, openConnections, runningConnections, wsrepLocalState, replication,
 		)
 		return
 	}
 
-	threadsRunning := threadsRunningResult["Value"]
 	tmp3, err := strconv.Atoi(threadsRunning)
 	if err != nil {
-		s.health.setUP(
-			fmt.Errorf("unexpected value for Threads_running returned from MySQL:  %s", err),
-			ioRunning, wsrepReady, secondsBehindMaster, openConnections, runningConnections, wsrepLocalState,
+		s.setHealthUP(
+			ctx, fmt.Errorf("unexpected value for Threads_running returned from MySQL:  %s", err),
+			ioRunning, wsrepReady, replicationLag, openConnections, runningConnections, wsrepLocalState, replication,
 		)
 		return
 	}
@@ -177,64 +314,193 @@ func (s *Server) CheckHealth(traceOn bool, logger Logger) {
 	runningConnections = &tmp3
 
 	if s.replicationMode == ReplicationModeSingleSource {
-		slaveStatusResult, err := s.rawQuery("SHOW SLAVE STATUS", logger)
+		columns := s.replicationStatusColumns()
+		slaveStatusResults, err := s.rawQueryAll(ctx, columns.query, logger)
 		if err != nil {
-			s.health.setUP(
-				err, ioRunning, wsrepReady, secondsBehindMaster, openConnections, runningConnections, wsrepLocalState,
+			s.setHealthUP(
+				ctx, err, ioRunning, wsrepReady, replicationLag, openConnections, runningConnections, wsrepLocalState, replication,
 			)
 			return
 		}
-		rawSecondsBehindMaster := strings.TrimSpace(slaveStatusResult["Seconds_Behind_Master"])
-		if rawSecondsBehindMaster == "" || strings.ToLower(rawSecondsBehindMaster) == "null" {
-			s.health.setUP(
-				fmt.Errorf("empty or null value for Seconds_Behind_Master returned from MySQL: %s", err),
-				ioRunning, wsrepReady, secondsBehindMaster, openConnections, runningConnections, wsrepLocalState,
-			)
-			return
+
+		channels := make([]ChannelStatus, len(slaveStatusResults))
+		for i, slaveStatusResult := range slaveStatusResults {
+			channels[i], err = parseChannelStatus(slaveStatusResult, columns)
+			if err != nil {
+				s.setHealthUP(
+					ctx, err, ioRunning, wsrepReady, replicationLag, openConnections, runningConnections, wsrepLocalState, replication,
+				)
+				return
+			}
 		}
 
-		tmp, err := strconv.Atoi(rawSecondsBehindMaster)
+		channels, err = selectChannels(channels, s.serverSettings.ReplicationChannels)
 		if err != nil {
-			s.health.setUP(
-				fmt.Errorf("unexpected value for Seconds_Behind_Master returned from MySQL (conversion error): %s", err),
-				ioRunning, wsrepReady, secondsBehindMaster, openConnections, runningConnections, wsrepLocalState,
+			s.setHealthUP(
+				ctx, err, ioRunning, wsrepReady, replicationLag, openConnections, runningConnections, wsrepLocalState, replication,
 			)
 			return
 		}
 
-		secondsBehindMaster = &tmp
+		status := aggregateChannels(channels)
+		if !s.hasSlaveRunningStatus() {
+			ioRunning = status.IORunning
+		}
+
+		replication = &replicationStatus{channels: channels, threads: status}
+
+		if s.heartbeatTable != "" {
+			lag, err := s.heartbeatLag(ctx, logger)
+			if err != nil {
+				s.setHealthUP(
+					ctx, err, ioRunning, wsrepReady, replicationLag, openConnections, runningConnections, wsrepLocalState, replication,
+				)
+				return
+			}
+
+			replicationLag = &lag
+		} else if status.SecondsBehindMaster != nil {
+			lag := time.Duration(*status.SecondsBehindMaster) * time.Second
+			replicationLag = &lag
+		}
+
+		if replicationLag == nil {
+			s.setHealthUP(
+				ctx, fmt.Errorf("empty or null value for %s returned from MySQL", columns.lag),
+				ioRunning, wsrepReady, replicationLag, openConnections, runningConnections, wsrepLocalState, replication,
+			)
+			return
+		}
 	} else if s.replicationMode == ReplicationModeMultiSourceWriteSet {
-		writesetStateResult, err := s.rawQuery("SHOW STATUS LIKE 'wsrep_local_state'", logger)
-		if err != nil {
-			s.health.setUP(
-				fmt.Errorf("failed acquiring MySQL wsrep_local_state:  %s", err),
-				ioRunning, wsrepReady, secondsBehindMaster, openConnections, runningConnections, wsrepLocalState,
+		writesetState, ok := status["wsrep_local_state"]
+		if !ok {
+			s.setHealthUP(
+				ctx, fmt.Errorf("failed acquiring MySQL wsrep_local_state:  %s", statusErr),
+				ioRunning, wsrepReady, replicationLag, openConnections, runningConnections, wsrepLocalState, replication,
 			)
 			return
 		}
 
-		writesetState := writesetStateResult["Value"]
 		tmp, err := strconv.Atoi(writesetState)
 		if err != nil {
-			s.health.setUP(
-				fmt.Errorf("unexpected value for wsrep_local_state returned from MySQL:  %s", err),
-				ioRunning, wsrepReady, secondsBehindMaster, openConnections, runningConnections, wsrepLocalState,
+			s.setHealthUP(
+				ctx, fmt.Errorf("unexpected value for wsrep_local_state returned from MySQL:  %s", err),
+				ioRunning, wsrepReady, replicationLag, openConnections, runningConnections, wsrepLocalState, replication,
 			)
 			return
 		}
 
 		wsrepLocalState = &tmp
+	} else if s.replicationMode == ReplicationModeGroupReplication {
+		applierQueueResult, err := s.rawQuery(
+			ctx, "SELECT COUNT_TRANSACTIONS_REMOTE_IN_APPLIER_QUEUE FROM performance_schema.replication_group_member_stats "+
+				"WHERE MEMBER_ID = @@server_uuid", logger,
+		)
+		if err != nil {
+			s.setHealthUP(
+				ctx, fmt.Errorf("failed acquiring MySQL group replication applier queue:  %s", err),
+				ioRunning, wsrepReady, replicationLag, openConnections, runningConnections, wsrepLocalState, replication,
+			)
+			return
+		}
+
+		tmp, err := strconv.Atoi(applierQueueResult["COUNT_TRANSACTIONS_REMOTE_IN_APPLIER_QUEUE"])
+		if err != nil {
+			s.setHealthUP(
+				ctx, fmt.Errorf("unexpected value for COUNT_TRANSACTIONS_REMOTE_IN_APPLIER_QUEUE returned from MySQL:  %s", err),
+				ioRunning, wsrepReady, replicationLag, openConnections, runningConnections, wsrepLocalState, replication,
+			)
+			return
+		}
+
+		replication.applierQueue = &tmp
 	}
 
-	s.health.setUP(nil, ioRunning, wsrepReady, secondsBehindMaster, openConnections, runningConnections, wsrepLocalState)
+	s.setHealthUP(ctx, nil, ioRunning, wsrepReady, replicationLag, openConnections, runningConnections, wsrepLocalState, replication)
 }
 
-func (s *Server) connectReadUser(traceOn bool, logger Logger) error {
+// checkPrimaryHealth sets a primary UP when it is reachable and writable
+func (s *Server) checkPrimaryHealth(ctx context.Context, traceOn bool, logger Logger) {
+	var nilHelper *int
+
+	if err := s.connectReadUser(ctx, traceOn, logger); err != nil {
+		s.setHealthDown(ctx, err, false, false, nil, nilHelper, nilHelper, nilHelper)
+		return
+	}
+
+	probeStart := time.Now()
+	readOnlyResult, err := s.queryRow(ctx, s.connection, "SELECT @@GLOBAL.read_only AS read_only", logger)
+	if err != nil {
+		s.setHealthDown(
+			ctx, fmt.Errorf("failed acquiring MySQL read_only: %s", err),
+			false, false, nil, nilHelper, nilHelper, nilHelper,
+		)
+		return
+	}
+
+	s.health.observeProbeLatency(time.Since(probeStart))
+
+	readOnly := strings.TrimSpace(readOnlyResult["read_only"])
+	if readOnly != "0" && !strings.EqualFold(readOnly, "OFF") {
+		s.setHealthDown(
+			ctx, fmt.Errorf("primary is read only (read_only=%s)", readOnly),
+			false, false, nil, nilHelper, nilHelper, nilHelper,
+		)
+		return
+	}
+
+	s.setHealthUP(ctx, nil, false, false, nil, nilHelper, nilHelper, nilHelper, nil)
+}
+
+// detectVersion reads the server version, which decides the replication
+// status statements used. It is retried on the next check on failure.
+func (s *Server) detectVersion(ctx context.Context, logger Logger) {
+	versionResult, err := s.rawQuery(ctx, "SELECT VERSION() AS version", logger)
+	if err != nil {
+		return
+	}
+
+	version, err := parseServerVersion(versionResult["version"])
+	if err != nil {
+		if logger != nil {
+			logger.Error(err)
+		}
+		return
+	}
+
+	s.version = &version
+}
+
+// replicationStatusColumns returns the replication status statement and
+// columns for the server's flavor
+func (s *Server) replicationStatusColumns() replicationStatusColumns {
+	switch {
+	case s.version != nil && s.version.mariaDB:
+		return mariaDBStatusColumns
+	case s.hasReplicaTerminology():
+		return replicaStatusColumns
+	default:
+		return slaveStatusColumns
+	}
+}
+
+// hasReplicaTerminology tells whether SHOW REPLICA STATUS must be used
+func (s *Server) hasReplicaTerminology() bool {
+	return s.version != nil && s.version.hasReplicaTerminology()
+}
+
+// hasSlaveRunningStatus tells whether the IO thread state comes from the
+// Slave_running status variable instead of the replication status
+func (s *Server) hasSlaveRunningStatus() bool {
+	return s.version == nil || s.version.hasSlaveRunningStatus()
+}
+
+func (s *Server) connectReadUser(ctx context.Context, traceOn bool, logger Logger) error {
 	s.connLock.Lock()
 	defer s.connLock.Unlock()
 
 	if s.connection == nil {
-		conn, err := s.connect(s.serverSettings.DSN, traceOn, logger)
+		conn, err := s.connect(ctx, s.serverSettings.DSN, traceOn, logger)
 		if err != nil {
 			return fmt.Errorf("could not connect to MySQL read user: %s", err.Error())
 		}
@@ -244,12 +510,12 @@ func (s *Server) connectReadUser(traceOn bool, logger Logger) error {
 	return nil
 }
 
-func (s *Server) connectReplicationUser(traceOn bool, logger Logger) error {
+func (s *Server) connectReplicationUser(ctx context.Context, traceOn bool, logger Logger) error {
 	s.connLock.Lock()
 	defer s.connLock.Unlock()
 
 	if s.replicationConnection == nil {
-		conn, err := s.connect(s.serverSettings.ReplicationDSN, traceOn, logger)
+		conn, err := s.connect(ctx, s.serverSettings.ReplicationDSN, traceOn, logger)
 		if err != nil {
 			return fmt.Errorf("could not connect to MySQL replication user: %s", err.Error())
 		}
@@ -259,8 +525,142 @@ func (s *Server) connectReplicationUser(traceOn bool, logger Logger) error {
 	return nil
 }
 
-func (s *Server) rawQuery(query string, logger Logger) (map[string]string, error) {
-	rows, err := s.replicationConnection.Db.Query(query)
+// MySQL error numbers that tell performance_schema.global_status can not be
+// used
+const (
+	mysqlErrTableAccessDenied   = 1142
+	mysqlErrNoSuchTable         = 1146
+	mysqlErrShowCompatibility56 = 3167 // show_compatibility_56 is ON on 5.7
+)
+
+// globalStatus returns the value of the given status variables, fetched in a
+// single query from performance_schema.global_status. Variables missing from
+// it, or every variable when the query fails, are fetched one by one with SHOW
+// STATUS, and the table is no longer tried once it is known to be unavailable. The error is the last one of the variables missing from
+// the result.
+func (s *Server) globalStatus(ctx context.Context, names []string, logger Logger) (map[string]string, error) {
+	status := make(map[string]string, len(names))
+
+	var batchErr error
+	unavailable := false
+	if !s.noGlobalStatusTable {
+		quoted := make([]string, len(names))
+		for i, name := range names {
+			quoted[i] = "'" + name + "'"
+		}
+
+		results, err := s.rawQueryAll(
+			ctx, "SELECT VARIABLE_NAME, VARIABLE_VALUE FROM performance_schema.global_status "+
+				"WHERE VARIABLE_NAME IN ("+strings.Join(quoted, ", ")+")", logger,
+		)
+		if err != nil && err != sql.ErrNoRows {
+			batchErr = err
+		}
+		unavailable = batchErr != nil && isGlobalStatusUnavailable(batchErr)
+
+		for _, result := range results {
+			for _, name := range names {
+				if strings.EqualFold(result["VARIABLE_NAME"], name) {
+					status[name] = result["VARIABLE_VALUE"]
+				}
+			}
+		}
+	}
+
+	var lastErr error
+	for _, name := range names {
+		if _, ok := status[name]; ok {
+			continue
+		}
+
+		result, err := s.rawQuery(ctx, "SHOW STATUS LIKE '"+name+"'", logger)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		status[name] = result["Value"]
+
+		// the server answers but has no usable global_status table (MariaDB,
+		// performance_schema disabled), stop trying it
+		if unavailable {
+			s.noGlobalStatusTable = true
+		}
+	}
+
+	return status, lastErr
+}
+
+// isGlobalStatusUnavailable tells whether err means the server has no usable
+// performance_schema.global_status table, rather than a transient failure
+func isGlobalStatusUnavailable(err error) bool {
+	switch mysqlErrorNumber(err) {
+	case mysqlErrTableAccessDenied, mysqlErrNoSuchTable, mysqlErrShowCompatibility56:
+		return true
+	}
+	return false
+}
+
+func (s *Server) rawQuery(ctx context.Context, query string, logger Logger) (map[string]string, error) {
+	return s.queryRow(ctx, s.replicationConnection, query, logger)
+}
+
+func (s *Server) rawQueryAll(ctx context.Context, query string, logger Logger) ([]map[string]string, error) {
+	return s.queryRows(ctx, s.replicationConnection, query, logger)
+}
+
+// queryRows returns every row of query as column name to value maps, failing
+// with sql.ErrNoRows when there is none
+func (s *Server) queryRows(ctx context.Context, connection *gorp.DbMap, query string, logger Logger) ([]map[string]string, error) {
+	rows, err := connection.Db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := rows.Close(); err != nil && logger != nil {
+			logger.Error(err)
+		}
+	}()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var results []map[string]string
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		for i := range values {
+			var v sql.RawBytes
+			values[i] = &v
+		}
+
+		if err := rows.Scan(values...); err != nil {
+			return nil, err
+		}
+
+		result := make(map[string]string)
+		for i, name := range columns {
+			bp := values[i].(*sql.RawBytes)
+			result[name] = string(*bp)
+		}
+		results = append(results, result)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(results) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return results, nil
+}
+
+// queryRow returns the first row of query as a column name to value map
+func (s *Server) queryRow(ctx context.Context, connection *gorp.DbMap, query string, logger Logger) (map[string]string, error) {
+	rows, err := connection.Db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -1,11 +1,13 @@
 package balancer
 
 import (
+	"context"
 	"database/sql"
 	"database/sql/driver"
 	"errors"
 	"regexp"
 	"testing"
+	"time"
 
 	sqlmock "github.com/DATA-DOG/go-sqlmock"
 	"github.com/go-gorp/gorp/v3"
@@ -48,40 +50,67 @@ func getMock(t *testing.T) (*gorp.DbMap, sqlmock.Sqlmock) {
 	return dbMap, mock
 }
 
-func mockHealthQueries(t *testing.T, mock sqlmock.Sqlmock, ioStatus, secondsBehindMaster, openConnections, runningConnections driver.Value) {
+// mockGlobalStatus expects the batched status probe, returning the given
+// variable name and value pairs
+func mockGlobalStatus(t *testing.T, mock sqlmock.Sqlmock, nameValues ...driver.Value) {
 	t.Helper()
 
-	mock.ExpectQuery("SHOW STATUS LIKE 'Slave_running'").WillReturnRows(
-		sqlmock.NewRows([]string{"Value"}).AddRow(ioStatus))
+	rows := sqlmock.NewRows([]string{"VARIABLE_NAME", "VARIABLE_VALUE"})
+	for i := 0; i+1 < len(nameValues); i += 2 {
+		rows.AddRow(nameValues[i], nameValues[i+1])
+	}
+	mock.ExpectQuery("SELECT VARIABLE_NAME, VARIABLE_VALUE FROM performance_schema.global_status").WillReturnRows(rows)
+}
+
+func mockHealthQueries(t *testing.T, mock sqlmock.Sqlmock, ioStatus, secondsBehindMaster, openConnections, runningConnections driver.Value) {
+	t.Helper()
 
-	mock.ExpectQuery("SHOW STATUS LIKE 'Threads_connected'").WillReturnRows(
-		sqlmock.NewRows([]string{"Value"}).AddRow(openConnections))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT VERSION()")).WillReturnRows(
+		sqlmock.NewRows([]string{"version"}).AddRow("5.7.30-log"))
 
-	mock.ExpectQuery("SHOW STATUS LIKE 'Threads_running'").WillReturnRows(
-		sqlmock.NewRows([]string{"Value"}).AddRow(runningConnections))
+	mockGlobalStatus(t, mock, "Slave_running", ioStatus, "Threads_connected", openConnections, "Threads_running", runningConnections)
 
 	mock.ExpectQuery("SHOW SLAVE STATUS").WillReturnRows(
 		sqlmock.NewRows([]string{"Seconds_Behind_Master"}).AddRow(secondsBehindMaster))
 
 }
 
+func mockHealthQueriesReplica(t *testing.T, mock sqlmock.Sqlmock, ioRunning, secondsBehindSource, openConnections, runningConnections driver.Value) {
+	t.Helper()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT VERSION()")).WillReturnRows(
+		sqlmock.NewRows([]string{"version"}).AddRow("8.0.23"))
+
+	mockGlobalStatus(t, mock, "Threads_connected", openConnections, "Threads_running", runningConnections)
+
+	mock.ExpectQuery("SHOW REPLICA STATUS").WillReturnRows(
+		sqlmock.NewRows([]string{"Replica_IO_Running", "Seconds_Behind_Source"}).AddRow(ioRunning, secondsBehindSource))
+
+}
+
 func mockHealthQueriesWriteSet(t *testing.T, mock sqlmock.Sqlmock, wsrepConnected, wsrepReady, openConnections, runningConnections, wsrepState driver.Value) {
 	t.Helper()
 
-	mock.ExpectQuery("SHOW STATUS LIKE 'wsrep_connected'").WillReturnRows(
-		sqlmock.NewRows([]string{"Value"}).AddRow(wsrepConnected))
+	mockGlobalStatus(t, mock,
+		"wsrep_connected", wsrepConnected,
+		"wsrep_ready", wsrepReady,
+		"Threads_connected", openConnections,
+		"Threads_running", runningConnections,
+		"wsrep_local_state", wsrepState,
+	)
 
-	mock.ExpectQuery("SHOW STATUS LIKE 'wsrep_ready'").WillReturnRows(
-		sqlmock.NewRows([]string{"Value"}).AddRow(wsrepReady))
+}
 
-	mock.ExpectQuery("SHOW STATUS LIKE 'Threads_connected'").WillReturnRows(
-		sqlmock.NewRows([]string{"Value"}).AddRow(openConnections))
+func mockHealthQueriesGroupReplication(t *testing.T, mock sqlmock.Sqlmock, memberState, memberRole, openConnections, runningConnections, applierQueue driver.Value) {
+	t.Helper()
 
-	mock.ExpectQuery("SHOW STATUS LIKE 'Threads_running'").WillReturnRows(
-		sqlmock.NewRows([]string{"Value"}).AddRow(runningConnections))
+	mockGlobalStatus(t, mock, "Threads_connected", openConnections, "Threads_running", runningConnections)
 
-	mock.ExpectQuery("SHOW STATUS LIKE 'wsrep_local_state'").WillReturnRows(
-		sqlmock.NewRows([]string{"Value"}).AddRow(wsrepState))
+	mock.ExpectQuery("SELECT MEMBER_STATE, MEMBER_ROLE FROM performance_schema.replication_group_members").WillReturnRows(
+		sqlmock.NewRows([]string{"MEMBER_STATE", "MEMBER_ROLE"}).AddRow(memberState, memberRole))
+
+	mock.ExpectQuery("SELECT COUNT_TRANSACTIONS_REMOTE_IN_APPLIER_QUEUE FROM performance_schema.replication_group_member_stats").WillReturnRows(
+		sqlmock.NewRows([]string{"COUNT_TRANSACTIONS_REMOTE_IN_APPLIER_QUEUE"}).AddRow(applierQueue))
 
 }
 
@@ -114,7 +143,7 @@ func TestRawQuery(t *testing.T) {
 			mock.ExpectQuery(query).WillReturnRows(
 				sqlmock.NewRows([]string{"Seconds_Behind_Master"}).AddRow(1))
 
-			results, err := server.rawQuery(query, logger)
+			results, err := server.rawQuery(context.Background(), query, logger)
 
 			Convey("It should return the expected result", func() {
 				So(err, ShouldBeNil)
@@ -130,7 +159,7 @@ func TestRawQuery(t *testing.T) {
 			mock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnRows(
 				sqlmock.NewRows([]string{"Foo"}))
 
-			results, err := server.rawQuery(query, logger)
+			results, err := server.rawQuery(context.Background(), query, logger)
 
 			Convey("It should fail with ErrNoRows", func() {
 				So(err, ShouldEqual, sql.ErrNoRows)
@@ -144,7 +173,7 @@ func TestRawQuery(t *testing.T) {
 			expectedError := errors.New("fail")
 
 			mock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnError(expectedError)
-			results, err := server.rawQuery(query, logger)
+			results, err := server.rawQuery(context.Background(), query, logger)
 
 			Convey("It should fail with expected error", func() {
 				So(err, ShouldEqual, expectedError)
@@ -161,7 +190,7 @@ func TestRawQuery(t *testing.T) {
 				sqlmock.NewRows([]string{"Foo"}).
 					AddRow(1).CloseError(expectedError))
 
-			results, err := server.rawQuery(query, logger)
+			results, err := server.rawQuery(context.Background(), query, logger)
 
 			Convey("It should fail with expected error", func() {
 				So(err, ShouldBeNil)
@@ -203,8 +232,10 @@ func TestCheckHealth(t *testing.T) {
 				So(health.openConnections, ShouldNotBeNil)
 				So(*health.openConnections, ShouldEqual, 2)
 
-				So(health.secondsBehindMaster, ShouldNotBeNil)
-				So(*health.secondsBehindMaster, ShouldEqual, 0)
+				So(health.replicationLag, ShouldNotBeNil)
+				So(*health.replicationLag, ShouldEqual, time.Duration(0))
+
+				So(health.GetProbeLatency(), ShouldNotBeNil)
 
 				So(mock.ExpectationsWereMet(), ShouldBeNil)
 			})
@@ -251,6 +282,29 @@ func TestCheckHealth(t *testing.T) {
 			})
 		})
 
+		Convey("When SQL thread stopped on an error", func() {
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT VERSION()")).WillReturnRows(
+				sqlmock.NewRows([]string{"version"}).AddRow("5.7.30"))
+			mockGlobalStatus(t, mock, "Slave_running", "ON", "Threads_connected", 1, "Threads_running", 1)
+			mock.ExpectQuery("SHOW SLAVE STATUS").WillReturnRows(
+				sqlmock.NewRows([]string{
+					"Slave_SQL_Running", "Last_IO_Errno", "Last_IO_Error", "Last_SQL_Errno", "Last_SQL_Error",
+					"Seconds_Behind_Master",
+				}).AddRow("No", 0, "", 1062, "Duplicate entry", nil))
+
+			Convey("It should set the SQL thread status and errors", func() {
+				server.CheckHealth(false, logger)
+				So(health.up, ShouldBeTrue)
+				So(health.ioRunning, ShouldBeTrue)
+				So(health.SQLRunning(), ShouldBeFalse)
+				So(health.GetLastIOErrno(), ShouldEqual, 0)
+				So(health.GetLastSQLErrno(), ShouldEqual, 1062)
+				So(health.GetLastSQLError(), ShouldEqual, "Duplicate entry")
+
+				So(mock.ExpectationsWereMet(), ShouldBeNil)
+			})
+		})
+
 		Convey("When IO is not running", func() {
 			mockHealthQueries(t, mock, "OFF", 0, 1, 1)
 
@@ -263,6 +317,174 @@ func TestCheckHealth(t *testing.T) {
 				mock.ExpectationsWereMet()
 			})
 		})
+
+		Convey("When a check fails after a successful one", func() {
+			health.fall = 3
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT VERSION()")).WillReturnRows(
+				sqlmock.NewRows([]string{"version"}).AddRow("5.7.30"))
+			mockGlobalStatus(t, mock, "Slave_running", "ON", "Threads_connected", 1, "Threads_running", 1)
+			mock.ExpectQuery("SHOW SLAVE STATUS").WillReturnRows(
+				sqlmock.NewRows([]string{"Slave_SQL_Running", "Seconds_Behind_Master"}).AddRow("Yes", 0))
+			server.CheckHealth(false, logger)
+			So(health.SQLRunning(), ShouldBeTrue)
+
+			Convey("It should keep the SQL thread status while the server stays UP", func() {
+				mockGlobalStatus(t, mock, "Slave_running", "ON", "Threads_connected", 1, "Threads_running", 1)
+				mock.ExpectQuery("SHOW SLAVE STATUS").WillReturnError(errors.New("fail"))
+				server.CheckHealth(false, logger)
+				So(health.SQLRunning(), ShouldBeTrue)
+				So(health.GetChannels(), ShouldHaveLength, 1)
+
+				server.connection = nil
+				server.CheckHealth(false, logger)
+				So(health.IsUP(), ShouldBeTrue)
+				So(health.SQLRunning(), ShouldBeTrue)
+				So(health.GetChannels(), ShouldHaveLength, 1)
+			})
+		})
+	})
+
+	Convey("Given a valid server using MySQL 8.0.22+ replica terminology (SingleSource)", t, func() {
+		db, mock := getMock(t)
+		logger := newLoggerMock()
+		health := new(ServerHealth)
+		server := Server{
+			connection:            db,
+			replicationConnection: db,
+			health:                health,
+			replicationMode:       ReplicationModeSingleSource,
+		}
+
+		Convey("When everything is ok", func() {
+			mockHealthQueriesReplica(t, mock, "Yes", 3, 2, 1)
+
+			Convey("It should succeed without errors", func() {
+				server.CheckHealth(false, logger)
+
+				So(health.up, ShouldBeTrue)
+				So(health.err, ShouldBeNil)
+				So(health.ioRunning, ShouldBeTrue)
+
+				So(health.replicationLag, ShouldNotBeNil)
+				So(*health.replicationLag, ShouldEqual, 3*time.Second)
+
+				So(mock.ExpectationsWereMet(), ShouldBeNil)
+			})
+		})
+
+		Convey("When replica status are empty", func() {
+			mockHealthQueriesReplica(t, mock, "No", nil, 2, 1)
+
+			Convey("It should set error on check", func() {
+				server.CheckHealth(false, logger)
+
+				So(health.up, ShouldBeTrue)
+				So(health.ioRunning, ShouldBeFalse)
+				So(health.err, ShouldNotBeNil)
+				So(health.err.Error(), ShouldContainSubstring, "empty or null value for Seconds_Behind_Source")
+
+				So(mock.ExpectationsWereMet(), ShouldBeNil)
+			})
+		})
+
+		Convey("When the version is already known", func() {
+			mockHealthQueriesReplica(t, mock, "Yes", 0, 2, 1)
+			server.CheckHealth(false, logger)
+
+			mockGlobalStatus(t, mock, "Threads_connected", 2, "Threads_running", 1)
+			mock.ExpectQuery("SHOW REPLICA STATUS").WillReturnRows(
+				sqlmock.NewRows([]string{"Replica_IO_Running", "Seconds_Behind_Source"}).AddRow("Yes", 0))
+
+			Convey("It should not be detected again", func() {
+				server.CheckHealth(false, logger)
+
+				So(health.err, ShouldBeNil)
+				So(mock.ExpectationsWereMet(), ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given a valid server with several replication channels (SingleSource)", t, func() {
+		db, mock := getMock(t)
+		logger := newLoggerMock()
+		health := new(ServerHealth)
+		server := Server{
+			connection:            db,
+			replicationConnection: db,
+			health:                health,
+			replicationMode:       ReplicationModeSingleSource,
+		}
+
+		mockChannels := func(version string, columns replicationStatusColumns) {
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT VERSION()")).WillReturnRows(
+				sqlmock.NewRows([]string{"version"}).AddRow(version))
+			mockGlobalStatus(t, mock, "Slave_running", "ON", "Threads_connected", 1, "Threads_running", 1)
+			mock.ExpectQuery(columns.query).WillReturnRows(
+				sqlmock.NewRows([]string{columns.channel, columns.sqlRunning, columns.lag}).
+					AddRow("orders", "Yes", 2).
+					AddRow("users", "No", 30))
+		}
+
+		Convey("When no channel is named", func() {
+			mockChannels("5.7.30", slaveStatusColumns)
+
+			Convey("It should aggregate every channel", func() {
+				server.CheckHealth(false, logger)
+
+				So(health.err, ShouldBeNil)
+				So(*health.replicationLag, ShouldEqual, 30*time.Second)
+				So(health.SQLRunning(), ShouldBeFalse)
+				So(health.GetChannels(), ShouldHaveLength, 2)
+
+				So(mock.ExpectationsWereMet(), ShouldBeNil)
+			})
+		})
+
+		Convey("When a channel is named", func() {
+			server.serverSettings.ReplicationChannels = []string{"orders"}
+			mockChannels("5.7.30", slaveStatusColumns)
+
+			Convey("It should only consider the named channel", func() {
+				server.CheckHealth(false, logger)
+
+				So(health.err, ShouldBeNil)
+				So(*health.replicationLag, ShouldEqual, 2*time.Second)
+				So(health.SQLRunning(), ShouldBeTrue)
+				So(health.GetChannels(), ShouldHaveLength, 1)
+				So(health.GetChannels()[0].Name, ShouldEqual, "orders")
+
+				So(mock.ExpectationsWereMet(), ShouldBeNil)
+			})
+		})
+
+		Convey("When a named channel is missing", func() {
+			server.serverSettings.ReplicationChannels = []string{"payments"}
+			mockChannels("5.7.30", slaveStatusColumns)
+
+			Convey("It should set error on check", func() {
+				server.CheckHealth(false, logger)
+
+				So(health.up, ShouldBeTrue)
+				So(health.err, ShouldNotBeNil)
+				So(health.err.Error(), ShouldContainSubstring, "replication channel")
+
+				So(mock.ExpectationsWereMet(), ShouldBeNil)
+			})
+		})
+
+		Convey("When the server is MariaDB", func() {
+			mockChannels("10.5.8-MariaDB", mariaDBStatusColumns)
+
+			Convey("It should use SHOW ALL SLAVES STATUS", func() {
+				server.CheckHealth(false, logger)
+
+				So(health.err, ShouldBeNil)
+				So(*health.replicationLag, ShouldEqual, 30*time.Second)
+				So(health.GetChannels()[1].Name, ShouldEqual, "users")
+
+				So(mock.ExpectationsWereMet(), ShouldBeNil)
+			})
+		})
 	})
 
 	Convey("Given a valid server using master-master replication using wsrep (MultiSourceWriteSet)", t, func() {
@@ -295,7 +517,7 @@ func TestCheckHealth(t *testing.T) {
 				So(health.openConnections, ShouldNotBeNil)
 				So(*health.openConnections, ShouldEqual, 2)
 
-				So(health.secondsBehindMaster, ShouldBeNil)
+				So(health.replicationLag, ShouldBeNil)
 
 				So(mock.ExpectationsWereMet(), ShouldBeNil)
 			})
@@ -356,6 +578,174 @@ func TestCheckHealth(t *testing.T) {
 		})
 	})
 
+	Convey("Given a valid server using group replication (GroupReplication)", t, func() {
+		db, mock := getMock(t)
+		logger := newLoggerMock()
+		health := new(ServerHealth)
+		server := Server{
+			connection:            db,
+			replicationConnection: db,
+			health:                health,
+			replicationMode:       ReplicationModeGroupReplication,
+		}
+
+		Convey("When everything is ok", func() {
+			mockHealthQueriesGroupReplication(t, mock, "ONLINE", "SECONDARY", 2, 1, 3)
+
+			Convey("It should succeed without errors", func() {
+				server.CheckHealth(false, logger)
+
+				So(health.up, ShouldBeTrue)
+				So(health.err, ShouldBeNil)
+				So(health.ioRunning, ShouldBeTrue)
+				So(health.GetGroupMemberState(), ShouldEqual, GroupMemberStateOnline)
+				So(health.IsGroupPrimary(), ShouldBeFalse)
+
+				So(health.applierQueue, ShouldNotBeNil)
+				So(*health.applierQueue, ShouldEqual, 3)
+
+				So(mock.ExpectationsWereMet(), ShouldBeNil)
+			})
+		})
+
+		Convey("When the member is the group primary", func() {
+			mockHealthQueriesGroupReplication(t, mock, "ONLINE", "PRIMARY", 2, 1, 0)
+
+			Convey("It should detect the primary role", func() {
+				server.CheckHealth(false, logger)
+
+				So(health.up, ShouldBeTrue)
+				So(health.IsGroupPrimary(), ShouldBeTrue)
+
+				So(mock.ExpectationsWereMet(), ShouldBeNil)
+			})
+		})
+
+		Convey("When the member is recovering", func() {
+			mockHealthQueriesGroupReplication(t, mock, "RECOVERING", "SECONDARY", 2, 1, 100)
+
+			Convey("It should set io running false", func() {
+				server.CheckHealth(false, logger)
+
+				So(health.up, ShouldBeTrue)
+				So(health.ioRunning, ShouldBeFalse)
+				So(health.GetGroupMemberState(), ShouldEqual, "RECOVERING")
+
+				So(mock.ExpectationsWereMet(), ShouldBeNil)
+			})
+		})
+
+		Convey("When applier queue is empty", func() {
+			mockHealthQueriesGroupReplication(t, mock, "ONLINE", "SECONDARY", 2, 1, nil)
+
+			Convey("It should set error on check", func() {
+				server.CheckHealth(false, logger)
+
+				So(health.up, ShouldBeTrue)
+				So(health.err, ShouldNotBeNil)
+				So(health.err.Error(), ShouldContainSubstring, "unexpected value for COUNT_TRANSACTIONS_REMOTE_IN_APPLIER_QUEUE")
+				So(health.applierQueue, ShouldBeNil)
+
+				So(mock.ExpectationsWereMet(), ShouldBeNil)
+			})
+		})
+
+		Convey("When member state can not be acquired", func() {
+			mockGlobalStatus(t, mock, "Threads_connected", 2, "Threads_running", 1)
+			mock.ExpectQuery("SELECT MEMBER_STATE, MEMBER_ROLE").WillReturnError(errors.New("fail"))
+
+			Convey("It should set error on check", func() {
+				server.CheckHealth(false, logger)
+
+				So(health.up, ShouldBeTrue)
+				So(health.err, ShouldNotBeNil)
+				So(health.err.Error(), ShouldContainSubstring, "failed acquiring MySQL group replication member state")
+				So(health.ioRunning, ShouldBeFalse)
+
+				So(mock.ExpectationsWereMet(), ShouldBeNil)
+			})
+		})
+
+		Convey("When a check fails after a successful one", func() {
+			health.fall = 3
+			mockHealthQueriesGroupReplication(t, mock, "ONLINE", "PRIMARY", 2, 1, 0)
+			server.CheckHealth(false, logger)
+
+			Convey("It should keep the member state while the server stays UP", func() {
+				mockGlobalStatus(t, mock, "Threads_connected", 2, "Threads_running", 1)
+				mock.ExpectQuery("SELECT MEMBER_STATE, MEMBER_ROLE").WillReturnError(errors.New("fail"))
+				server.CheckHealth(false, logger)
+				So(health.GetErr(), ShouldNotBeNil)
+				So(health.GetGroupMemberState(), ShouldEqual, GroupMemberStateOnline)
+
+				server.connection = nil
+				server.CheckHealth(false, logger)
+				So(health.IsUP(), ShouldBeTrue)
+				So(health.GetGroupMemberState(), ShouldEqual, GroupMemberStateOnline)
+				So(health.IsGroupPrimary(), ShouldBeTrue)
+			})
+
+			Convey("It should clear it once the server left the group", func() {
+				mockGlobalStatus(t, mock, "Threads_connected", 2, "Threads_running", 1)
+				mock.ExpectQuery("SELECT MEMBER_STATE, MEMBER_ROLE").WillReturnRows(
+					sqlmock.NewRows([]string{"MEMBER_STATE", "MEMBER_ROLE"}))
+				server.CheckHealth(false, logger)
+				So(health.GetGroupMemberState(), ShouldEqual, "")
+				So(health.IsGroupPrimary(), ShouldBeFalse)
+			})
+		})
+	})
+
+	Convey("Given a valid primary server", t, func() {
+		db, mock := getMock(t)
+		logger := newLoggerMock()
+		health := new(ServerHealth)
+		server := Server{
+			connection:     db,
+			health:         health,
+			serverSettings: ServerSettings{Role: ServerRolePrimary},
+		}
+
+		Convey("When read_only is OFF", func() {
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT @@GLOBAL.read_only")).WillReturnRows(
+				sqlmock.NewRows([]string{"read_only"}).AddRow(0))
+
+			Convey("It should be UP", func() {
+				server.CheckHealth(false, logger)
+
+				So(health.up, ShouldBeTrue)
+				So(health.err, ShouldBeNil)
+				So(mock.ExpectationsWereMet(), ShouldBeNil)
+			})
+		})
+
+		Convey("When read_only is ON", func() {
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT @@GLOBAL.read_only")).WillReturnRows(
+				sqlmock.NewRows([]string{"read_only"}).AddRow(1))
+
+			Convey("It should be DOWN", func() {
+				server.CheckHealth(false, logger)
+
+				So(health.up, ShouldBeFalse)
+				So(health.err, ShouldNotBeNil)
+				So(health.err.Error(), ShouldContainSubstring, "primary is read only")
+				So(mock.ExpectationsWereMet(), ShouldBeNil)
+			})
+		})
+
+		Convey("When read_only can not be acquired", func() {
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT @@GLOBAL.read_only")).WillReturnError(errors.New("fail"))
+
+			Convey("It should be DOWN", func() {
+				server.CheckHealth(false, logger)
+
+				So(health.up, ShouldBeFalse)
+				So(health.err, ShouldNotBeNil)
+				So(mock.ExpectationsWereMet(), ShouldBeNil)
+			})
+		})
+	})
+
 	Convey("Given a invalid server", t, func() {
 		logger := newLoggerMock()
 		health := new(ServerHealth)
@@ -402,3 +792,144 @@ func TestCheckHealth(t *testing.T) {
 	})
 
 }
+
+func TestCheckHealthContext(t *testing.T) {
+	Convey("Given a replica that hangs on a status query", t, func() {
+		db, mock := getMock(t)
+		logger := newLoggerMock()
+		health := new(ServerHealth)
+		server := Server{
+			name:                  "hanging",
+			connection:            db,
+			replicationConnection: db,
+			health:                health,
+			replicationMode:       ReplicationModeSingleSource,
+			healthCheckTimeout:    50 * time.Millisecond,
+		}
+
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT VERSION()")).WillReturnRows(
+			sqlmock.NewRows([]string{"version"}).AddRow("5.7.30"))
+		mock.ExpectQuery("SELECT VARIABLE_NAME, VARIABLE_VALUE FROM performance_schema.global_status").
+			WillDelayFor(time.Minute).
+			WillReturnRows(sqlmock.NewRows([]string{"VARIABLE_NAME", "VARIABLE_VALUE"}))
+
+		Convey("It should give up after the timeout and set the server DOWN", func() {
+			start := time.Now()
+			server.CheckHealth(false, logger)
+
+			So(time.Since(start), ShouldBeLessThan, 10*time.Second)
+			So(health.up, ShouldBeFalse)
+
+			var timeoutErr *HealthCheckTimeoutError
+			So(errors.As(health.err, &timeoutErr), ShouldBeTrue)
+			So(timeoutErr.Server, ShouldEqual, "hanging")
+			So(timeoutErr.After, ShouldBeGreaterThanOrEqualTo, 50*time.Millisecond)
+			So(errors.Is(health.err, context.DeadlineExceeded), ShouldBeTrue)
+		})
+
+		Convey("It should give up when the context is canceled", func() {
+			server.healthCheckTimeout = 0
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			server.CheckHealthContext(ctx, false, logger)
+
+			So(health.up, ShouldBeFalse)
+			So(errors.Is(health.err, context.DeadlineExceeded), ShouldBeTrue)
+		})
+	})
+}
+
+func TestGlobalStatus(t *testing.T) {
+	Convey("Given a valid connection", t, func() {
+		db, mock := getMock(t)
+		logger := newLoggerMock()
+		server := Server{connection: db, replicationConnection: db}
+		ctx := context.Background()
+		names := []string{"Threads_connected", "Threads_running"}
+
+		Convey("When performance_schema.global_status is available", func() {
+			mockGlobalStatus(t, mock, "THREADS_CONNECTED", 2, "Threads_running", 1)
+
+			Convey("It should fetch every variable in a single query", func() {
+				status, err := server.globalStatus(ctx, names, logger)
+				So(err, ShouldBeNil)
+				So(status, ShouldResemble, map[string]string{"Threads_connected": "2", "Threads_running": "1"})
+				So(mock.ExpectationsWereMet(), ShouldBeNil)
+			})
+		})
+
+		Convey("When a variable is missing from performance_schema.global_status", func() {
+			mockGlobalStatus(t, mock, "Threads_connected", 2)
+			mock.ExpectQuery("SHOW STATUS LIKE 'Threads_running'").WillReturnRows(
+				sqlmock.NewRows([]string{"Value"}).AddRow(1))
+
+			Convey("It should fetch it with SHOW STATUS", func() {
+				status, err := server.globalStatus(ctx, names, logger)
+				So(err, ShouldBeNil)
+				So(status, ShouldResemble, map[string]string{"Threads_connected": "2", "Threads_running": "1"})
+				So(server.noGlobalStatusTable, ShouldBeFalse)
+				So(mock.ExpectationsWereMet(), ShouldBeNil)
+			})
+		})
+
+		Convey("When performance_schema.global_status is unavailable", func() {
+			mock.ExpectQuery("SELECT VARIABLE_NAME, VARIABLE_VALUE FROM performance_schema.global_status").
+				WillReturnError(errors.New("Error 1146: Table 'performance_schema.global_status' doesn't exist"))
+			mock.ExpectQuery("SHOW STATUS LIKE 'Threads_connected'").WillReturnRows(
+				sqlmock.NewRows([]string{"Value"}).AddRow(2))
+			mock.ExpectQuery("SHOW STATUS LIKE 'Threads_running'").WillReturnRows(
+				sqlmock.NewRows([]string{"Value"}).AddRow(1))
+
+			Convey("It should fall back to SHOW STATUS and stop trying it", func() {
+				status, err := server.globalStatus(ctx, names, logger)
+				So(err, ShouldBeNil)
+				So(status, ShouldResemble, map[string]string{"Threads_connected": "2", "Threads_running": "1"})
+				So(server.noGlobalStatusTable, ShouldBeTrue)
+
+				mock.ExpectQuery("SHOW STATUS LIKE 'Threads_connected'").WillReturnRows(
+					sqlmock.NewRows([]string{"Value"}).AddRow(3))
+				mock.ExpectQuery("SHOW STATUS LIKE 'Threads_running'").WillReturnRows(
+					sqlmock.NewRows([]string{"Value"}).AddRow(1))
+
+				status, err = server.globalStatus(ctx, names, logger)
+				So(err, ShouldBeNil)
+				So(status["Threads_connected"], ShouldEqual, "3")
+				So(mock.ExpectationsWereMet(), ShouldBeNil)
+			})
+		})
+
+		Convey("When performance_schema.global_status fails transiently", func() {
+			mock.ExpectQuery("SELECT VARIABLE_NAME, VARIABLE_VALUE FROM performance_schema.global_status").
+				WillReturnError(errors.New("Error 1205: Lock wait timeout exceeded; try restarting transaction"))
+			mock.ExpectQuery("SHOW STATUS LIKE 'Threads_connected'").WillReturnRows(
+				sqlmock.NewRows([]string{"Value"}).AddRow(2))
+			mock.ExpectQuery("SHOW STATUS LIKE 'Threads_running'").WillReturnRows(
+				sqlmock.NewRows([]string{"Value"}).AddRow(1))
+
+			Convey("It should fall back to SHOW STATUS and keep trying it", func() {
+				status, err := server.globalStatus(ctx, names, logger)
+				So(err, ShouldBeNil)
+				So(status, ShouldResemble, map[string]string{"Threads_connected": "2", "Threads_running": "1"})
+				So(server.noGlobalStatusTable, ShouldBeFalse)
+				So(mock.ExpectationsWereMet(), ShouldBeNil)
+			})
+		})
+
+		Convey("When the server does not answer", func() {
+			expectedErr := errors.New("connection refused")
+			mock.ExpectQuery("SELECT VARIABLE_NAME, VARIABLE_VALUE FROM performance_schema.global_status").
+				WillReturnError(expectedErr)
+			mock.ExpectQuery("SHOW STATUS LIKE 'Threads_connected'").WillReturnError(expectedErr)
+			mock.ExpectQuery("SHOW STATUS LIKE 'Threads_running'").WillReturnError(expectedErr)
+
+			Convey("It should fail and keep trying performance_schema.global_status", func() {
+				status, err := server.globalStatus(ctx, names, logger)
+				So(err, ShouldEqual, expectedErr)
+				So(status, ShouldBeEmpty)
+				So(server.noGlobalStatusTable, ShouldBeFalse)
+				So(mock.ExpectationsWereMet(), ShouldBeNil)
+			})
+		})
+	})
+}
@@ -3,6 +3,8 @@ package balancer
 import (
 	"math"
 	"sort"
+	"strings"
+	"time"
 )
 
 // Servers - list of servers
@@ -15,33 +17,113 @@ func (s Servers) eachASYNC(fn func(int, *Server)) Servers {
 	return s
 }
 
-func (s Servers) filterBySecondsBehindMaster() Servers {
-	minValue := math.MaxInt64
-	indexesByValue := make(map[int][]int)
+// without returns a copy of s without server
+func (s Servers) without(server *Server) Servers {
+	filteredServers := make(Servers, 0, len(s))
+	for i := range s {
+		if s[i] != server {
+			filteredServers = append(filteredServers, s[i])
+		}
+	}
+	return filteredServers
+}
 
+// filterByReplicationLag returns the servers lagging at most tolerance more
+// than the less lagged one, sorted by replication lag
+func (s Servers) filterByReplicationLag(tolerance time.Duration) Servers {
+	minValue := time.Duration(math.MaxInt64)
 	for i := 0; i < len(s); i++ {
-		current := s[i].health.secondsBehindMaster
-		if current == nil {
+		current := s[i].health.replicationLag
+		if current != nil && *current < minValue {
+			minValue = *current
+		}
+	}
+
+	var filteredServers Servers
+	for i := range s {
+		current := s[i].health.replicationLag
+		if current == nil || *current-minValue > tolerance {
 			continue
 		}
+		filteredServers = append(filteredServers, s[i])
+	}
 
-		indexesByValue[*current] = append(indexesByValue[*current], i)
-		if *current < minValue {
-			minValue = *current
+	sort.Stable(byReplicationLag(filteredServers))
+	return filteredServers
+}
+
+// filterByMaxReplicationLag returns the servers known to be lagging at most
+// max
+func (s Servers) filterByMaxReplicationLag(max time.Duration) Servers {
+	var filteredServers Servers
+	for i := range s {
+		current := s[i].health.replicationLag
+		if current == nil || *current > max {
+			continue
+		}
+		filteredServers = append(filteredServers, s[i])
+	}
+	return filteredServers
+}
+
+// filterByCircuitBreaker returns the servers not ejected by their circuit
+// breaker
+func (s Servers) filterByCircuitBreaker() Servers {
+	var filteredServers Servers
+	for i := range s {
+		if !s[i].IsEjected() {
+			filteredServers = append(filteredServers, s[i])
+		}
+	}
+	return filteredServers
+}
+
+// filterByZone returns the servers in zone
+func (s Servers) filterByZone(zone string) Servers {
+	var filteredServers Servers
+	for i := range s {
+		if s[i].GetZone() == zone {
+			filteredServers = append(filteredServers, s[i])
 		}
 	}
+	return filteredServers
+}
 
+// filterByRegion returns the servers in region
+func (s Servers) filterByRegion(region string) Servers {
 	var filteredServers Servers
 	for i := range s {
-		for _, index := range indexesByValue[minValue] {
-			if i != index {
-				continue
-			}
+		if s[i].GetRegion() == region {
 			filteredServers = append(filteredServers, s[i])
 		}
 	}
+	return filteredServers
+}
 
-	sort.Sort(bySecondsBehindMaster(filteredServers))
+// load returns the running connections per unit of weight of the servers
+// reporting them
+func (s Servers) load() float64 {
+	running, weight := 0, 0
+	for i := range s {
+		if current := s[i].health.GetRunningConnections(); current != nil {
+			running += *current
+			weight += s[i].GetWeight()
+		}
+	}
+	if weight == 0 {
+		return 0
+	}
+	return float64(running) / float64(weight)
+}
+
+// filterBySQLRunning returns the servers whose SQL thread is running
+func (s Servers) filterBySQLRunning() Servers {
+	var filteredServers Servers
+	for i := range s {
+		if s[i].health.SQLRunning() {
+			filteredServers = append(filteredServers, s[i])
+		}
+	}
 	return filteredServers
 }
 
@@ -62,3 +144,36 @@ func (s Servers) filterByWriteSetStatus() Servers {
 
 	return filteredServers
 }
+
+// filterByGroupMemberState returns the servers in the given replication group
+// member state
+func (s Servers) filterByGroupMemberState(state string) Servers {
+	var filteredServers Servers
+	for i := range s {
+		if strings.EqualFold(s[i].health.GetGroupMemberState(), state) {
+			filteredServers = append(filteredServers, s[i])
+		}
+	}
+	return filteredServers
+}
+
+// filterByApplierQueue returns the servers with the fewest transactions in the
+// group replication applier queue
+func (s Servers) filterByApplierQueue() Servers {
+	minValue := math.MaxInt64
+	for i := range s {
+		current := s[i].health.GetTransactionsInApplierQueue()
+		if current != nil && *current < minValue {
+			minValue = *current
+		}
+	}
+
+	var filteredServers Servers
+	for i := range s {
+		current := s[i].health.GetTransactionsInApplierQueue()
+		if current != nil && *current == minValue {
+			filteredServers = append(filteredServers, s[i])
+		}
+	}
+	return filteredServers
+}
@@ -3,6 +3,7 @@ package balancer
 import (
 	"sync"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -34,3 +35,34 @@ func TestEachASYNC(t *testing.T) {
 		})
 	})
 }
+
+func TestFilterByReplicationLag(t *testing.T) {
+	Convey("When a list of servers are given", t, func() {
+		servers := Servers{
+			{name: "server_1", health: &ServerHealth{replicationLag: &[]time.Duration{3 * time.Second}[0]}},
+			{name: "server_2", health: &ServerHealth{replicationLag: nil}},
+			{name: "server_3", health: &ServerHealth{replicationLag: &[]time.Duration{time.Second}[0]}},
+			{name: "server_4", health: &ServerHealth{replicationLag: &[]time.Duration{2 * time.Second}[0]}},
+		}
+
+		Convey("It keeps only the less lagged servers without tolerance", func() {
+			filtered := servers.filterByReplicationLag(0)
+			So(filtered, ShouldHaveLength, 1)
+			So(filtered[0].name, ShouldEqual, "server_3")
+		})
+
+		Convey("It keeps the servers within the tolerance sorted by lag", func() {
+			filtered := servers.filterByReplicationLag(time.Second)
+			So(filtered, ShouldHaveLength, 2)
+			So(filtered[0].name, ShouldEqual, "server_3")
+			So(filtered[1].name, ShouldEqual, "server_4")
+		})
+
+		Convey("It keeps the servers below the maximum lag", func() {
+			filtered := servers.filterByMaxReplicationLag(2 * time.Second)
+			So(filtered, ShouldHaveLength, 2)
+			So(filtered[0].name, ShouldEqual, "server_3")
+			So(filtered[1].name, ShouldEqual, "server_4")
+		})
+	})
+}
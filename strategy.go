@@ -0,0 +1,290 @@
+package balancer
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Strategy chooses a server from a snapshot of the UP servers. Pick is called
+// with at least one candidate and may be called concurrently.
+type Strategy interface {
+	Pick(candidates Servers) *Server
+}
+
+// StrategyFunc adapts an ordinary function to the Strategy interface
+type StrategyFunc func(candidates Servers) *Server
+
+// Pick calls f(candidates)
+func (f StrategyFunc) Pick(candidates Servers) *Server {
+	return f(candidates)
+}
+
+// defaultStrategy keeps the servers with the best replication state and picks
+// the one with fewer connections among them
+type defaultStrategy struct {
+	config *Config
+}
+
+// NewDefaultStrategy returns the strategy used when Config.Strategy is not set
+func NewDefaultStrategy(config *Config) Strategy {
+	return &defaultStrategy{config: config}
+}
+
+// filter returns the candidates with the best replication state or all the
+// candidates when none of them reports it
+func (s *defaultStrategy) filter(candidates Servers) Servers {
+	var filtered Servers
+	switch s.config.ReplicationMode {
+	case ReplicationModeMultiSourceWriteSet:
+		filtered = candidates.filterByWriteSetStatus()
+	case ReplicationModeGroupReplication:
+		filtered = candidates.filterByApplierQueue()
+	default:
+		filtered = candidates.filterByReplicationLag(s.lagTolerance())
+	}
+
+	if len(filtered) == 0 {
+		return candidates
+	}
+	return filtered
+}
+
+// lagTolerance returns how much a replica may lag behind the less lagged one
+// and still be eligible
+func (s *defaultStrategy) lagTolerance() time.Duration {
+	if s.config.MaxLagTolerance > 0 {
+		return s.config.MaxLagTolerance
+	}
+	if s.config.MaxSecondsBehindMaster > 0 {
+		return time.Duration(s.config.MaxSecondsBehindMaster) * time.Second
+	}
+	return 0
+}
+
+func (s *defaultStrategy) Pick(candidates Servers) *Server {
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+
+	filtered := s.filter(candidates)
+	if len(filtered) == 1 {
+		return filtered[0]
+	}
+
+	sorted := make(Servers, len(filtered))
+	copy(sorted, filtered)
+	sort.Sort(byConnections(sorted))
+	return sorted[0]
+}
+
+type leastConnectionsStrategy struct{}
+
+// NewLeastConnectionsStrategy returns a strategy that picks the server with
+// fewer running (then open) connections, ignoring the replication state
+func NewLeastConnectionsStrategy() Strategy {
+	return leastConnectionsStrategy{}
+}
+
+func (leastConnectionsStrategy) Pick(candidates Servers) *Server {
+	sorted := make(Servers, len(candidates))
+	copy(sorted, candidates)
+	sort.Stable(byConnections(sorted))
+	return sorted[0]
+}
+
+type roundRobinStrategy struct {
+	next uint64
+}
+
+// NewRoundRobinStrategy returns a strategy that cycles through the candidates
+func NewRoundRobinStrategy() Strategy {
+	return &roundRobinStrategy{}
+}
+
+func (s *roundRobinStrategy) Pick(candidates Servers) *Server {
+	n := atomic.AddUint64(&s.next, 1) - 1
+	return candidates[n%uint64(len(candidates))]
+}
+
+// lockedRand is a *rand.Rand safe for concurrent use
+type lockedRand struct {
+	sync.Mutex
+	rand *rand.Rand
+}
+
+func newLockedRand(src rand.Source) *lockedRand {
+	if src == nil {
+		src = rand.NewSource(time.Now().UnixNano())
+	}
+	return &lockedRand{rand: rand.New(src)}
+}
+
+func (r *lockedRand) Intn(n int) int {
+	r.Lock()
+	defer r.Unlock()
+	return r.rand.Intn(n)
+}
+
+type randomStrategy struct {
+	rand *lockedRand
+}
+
+// NewRandomStrategy returns a strategy that picks a uniformly random
+// candidate. A nil src uses a time seeded source.
+func NewRandomStrategy(src rand.Source) Strategy {
+	return &randomStrategy{rand: newLockedRand(src)}
+}
+
+func (s *randomStrategy) Pick(candidates Servers) *Server {
+	return candidates[s.rand.Intn(len(candidates))]
+}
+
+type p2cStrategy struct {
+	defaultStrategy
+	rand *lockedRand
+}
+
+// NewP2CStrategy returns a strategy that samples two candidates at random and
+// picks the one with the lower score: its running connections per unit of
+// weight, plus one per second of replication lag (per transaction in the
+// applier queue with ReplicationModeGroupReplication). Unlike the default
+// strategy, every candidate within Config.MaxSecondsBehindMaster may be
+// sampled, not only the less lagged ones, so it spreads the load between
+// checks. With ReplicationModeMultiSourceWriteSet, only the synced candidates
+// are sampled. A nil src uses a time seeded source.
+func NewP2CStrategy(config *Config, src rand.Source) Strategy {
+	return &p2cStrategy{defaultStrategy: defaultStrategy{config: config}, rand: newLockedRand(src)}
+}
+
+// score returns the load and lag score of server, or +Inf when one of them is
+// unknown
+func (s *p2cStrategy) score(server *Server) float64 {
+	running := server.health.GetRunningConnections()
+	if running == nil {
+		return math.Inf(1)
+	}
+	score := float64(*running) / float64(server.GetWeight())
+
+	switch s.config.ReplicationMode {
+	case ReplicationModeSingleSource:
+		lag := server.health.GetReplicationLag()
+		if lag == nil {
+			return math.Inf(1)
+		}
+		score += lag.Seconds()
+	case ReplicationModeGroupReplication:
+		applierQueue := server.health.GetTransactionsInApplierQueue()
+		if applierQueue == nil {
+			return math.Inf(1)
+		}
+		score += float64(*applierQueue)
+	}
+	return score
+}
+
+func (s *p2cStrategy) Pick(candidates Servers) *Server {
+	if s.config.ReplicationMode == ReplicationModeMultiSourceWriteSet {
+		candidates = s.filter(candidates)
+	}
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+
+	i := s.rand.Intn(len(candidates))
+	j := s.rand.Intn(len(candidates) - 1)
+	if j >= i {
+		j++
+	}
+
+	scoreI, scoreJ := s.score(candidates[i]), s.score(candidates[j])
+	if scoreJ < scoreI || scoreJ == scoreI && byConnections(candidates).Less(j, i) {
+		return candidates[j]
+	}
+	return candidates[i]
+}
+
+type smoothWeightedRoundRobinStrategy struct {
+	sync.Mutex
+	current map[string]int
+}
+
+// NewSmoothWeightedRoundRobinStrategy returns a strategy that cycles through
+// the candidates in proportion to their weight (see ServerSettings.Weight),
+// interleaving them as nginx does: weights 5, 1, 1 pick a, a, b, a, c, a, a
+func NewSmoothWeightedRoundRobinStrategy() Strategy {
+	return &smoothWeightedRoundRobinStrategy{current: make(map[string]int)}
+}
+
+func (s *smoothWeightedRoundRobinStrategy) Pick(candidates Servers) *Server {
+	s.Lock()
+	defer s.Unlock()
+
+	var best *Server
+	total := 0
+	for _, server := range candidates {
+		weight := server.GetWeight()
+		total += weight
+		s.current[server.name] += weight
+		if best == nil || s.current[server.name] > s.current[best.name] {
+			best = server
+		}
+	}
+
+	s.current[best.name] -= total
+	return best
+}
+
+type weightedStrategy struct {
+	weights map[string]int
+	rand    *lockedRand
+}
+
+// NewWeightedStrategy returns a strategy that picks a random candidate with
+// probability proportional to its weight. weights, indexed by server name,
+// overrides the servers' own weight (see ServerSettings.Weight and
+// Balancer.SetWeight), which is used for the servers missing from it, so a nil
+// map uses the servers' weights. Servers with weight <= 0 in weights are only
+// picked when no other candidate is available. A nil src uses a time seeded
+// source.
+func NewWeightedStrategy(weights map[string]int, src rand.Source) Strategy {
+	return &weightedStrategy{weights: weights, rand: newLockedRand(src)}
+}
+
+func (s *weightedStrategy) weight(server *Server) int {
+	weight, ok := s.weights[server.name]
+	if !ok {
+		return server.GetWeight()
+	}
+	return weight
+}
+
+func (s *weightedStrategy) Pick(candidates Servers) *Server {
+	total := 0
+	for _, server := range candidates {
+		if weight := s.weight(server); weight > 0 {
+			total += weight
+		}
+	}
+
+	if total == 0 {
+		return candidates[0]
+	}
+
+	n := s.rand.Intn(total)
+	for _, server := range candidates {
+		weight := s.weight(server)
+		if weight <= 0 {
+			continue
+		}
+		if n < weight {
+			return server
+		}
+		n -= weight
+	}
+
+	return candidates[len(candidates)-1]
+}
@@ -0,0 +1,233 @@
+package balancer
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDefaultStrategy(t *testing.T) {
+	Convey("Given the default strategy", t, func() {
+		strategy := NewDefaultStrategy(&Config{})
+
+		Convey("It picks the less lagged server with fewer connections", func() {
+			So(strategy.Pick(Servers{
+				ServerUPWithDelay,
+				ServerUPWithHighThreadConnections,
+				ServerUP,
+			}), ShouldPointTo, ServerUP)
+		})
+
+		Convey("It does not reorder the given candidates", func() {
+			candidates := Servers{ServerUPWithHighThreadConnections, ServerUP}
+			strategy.Pick(candidates)
+			So(candidates[0], ShouldPointTo, ServerUPWithHighThreadConnections)
+			So(candidates[1], ShouldPointTo, ServerUP)
+		})
+	})
+}
+
+func TestLeastConnectionsStrategy(t *testing.T) {
+	Convey("Given the least connections strategy", t, func() {
+		strategy := NewLeastConnectionsStrategy()
+
+		Convey("It ignores the replication state", func() {
+			So(strategy.Pick(Servers{
+				ServerUPWithHighThreadConnections,
+				ServerUPWithDelay,
+			}), ShouldPointTo, ServerUPWithDelay)
+		})
+	})
+}
+
+func TestRoundRobinStrategy(t *testing.T) {
+	Convey("Given the round robin strategy", t, func() {
+		strategy := NewRoundRobinStrategy()
+		candidates := Servers{ServerUP, ServerUPWithDelay, ServerUPWithNoSync}
+
+		Convey("It cycles through the candidates", func() {
+			So(strategy.Pick(candidates), ShouldPointTo, ServerUP)
+			So(strategy.Pick(candidates), ShouldPointTo, ServerUPWithDelay)
+			So(strategy.Pick(candidates), ShouldPointTo, ServerUPWithNoSync)
+			So(strategy.Pick(candidates), ShouldPointTo, ServerUP)
+		})
+	})
+}
+
+func TestRandomStrategy(t *testing.T) {
+	Convey("Given the random strategy", t, func() {
+		strategy := NewRandomStrategy(rand.NewSource(1))
+		candidates := Servers{ServerUP, ServerUPWithDelay}
+
+		Convey("It picks every candidate eventually", func() {
+			picked := make(map[*Server]bool)
+			for i := 0; i < 100; i++ {
+				picked[strategy.Pick(candidates)] = true
+			}
+			So(picked, ShouldHaveLength, 2)
+		})
+	})
+}
+
+func TestWeightedStrategy(t *testing.T) {
+	Convey("Given the weighted strategy", t, func() {
+		candidates := Servers{ServerUP, ServerUPWithDelay, ServerUPWithNoSync}
+
+		Convey("It never picks servers with weight zero", func() {
+			strategy := NewWeightedStrategy(map[string]int{
+				"ServerUP":           0,
+				"ServerUPWithDelay":  3,
+				"ServerUPWithNoSync": 0,
+			}, rand.NewSource(1))
+
+			for i := 0; i < 100; i++ {
+				So(strategy.Pick(candidates), ShouldPointTo, ServerUPWithDelay)
+			}
+		})
+
+		Convey("It respects the weights proportion", func() {
+			strategy := NewWeightedStrategy(map[string]int{
+				"ServerUP":          9,
+				"ServerUPWithDelay": 1,
+			}, rand.NewSource(1))
+
+			picked := make(map[*Server]int)
+			for i := 0; i < 1000; i++ {
+				picked[strategy.Pick(candidates)]++
+			}
+			So(picked[ServerUP], ShouldBeGreaterThan, picked[ServerUPWithDelay])
+			So(picked[ServerUP], ShouldBeGreaterThan, picked[ServerUPWithNoSync])
+		})
+
+		Convey("It uses the servers' weight when missing from the weights", func() {
+			heavy := &Server{name: "heavy", health: &ServerHealth{}, weight: 9}
+			light := &Server{name: "light", health: &ServerHealth{}}
+			strategy := NewWeightedStrategy(nil, rand.NewSource(1))
+
+			picked := make(map[*Server]int)
+			for i := 0; i < 1000; i++ {
+				picked[strategy.Pick(Servers{heavy, light})]++
+			}
+			So(picked[heavy], ShouldBeGreaterThan, 3*picked[light])
+
+			Convey("Unless weights overrides it", func() {
+				strategy := NewWeightedStrategy(map[string]int{"heavy": 0}, rand.NewSource(1))
+				for i := 0; i < 100; i++ {
+					So(strategy.Pick(Servers{heavy, light}), ShouldPointTo, light)
+				}
+			})
+		})
+
+		Convey("It falls back to the first candidate when all weights are zero", func() {
+			strategy := NewWeightedStrategy(map[string]int{
+				"ServerUP":           0,
+				"ServerUPWithDelay":  0,
+				"ServerUPWithNoSync": 0,
+			}, nil)
+			So(strategy.Pick(candidates), ShouldPointTo, ServerUP)
+		})
+	})
+}
+
+func TestP2CStrategy(t *testing.T) {
+	Convey("Given the power of two choices strategy", t, func() {
+		newReplica := func(name string, runningConnections int) *Server {
+			server := &Server{name: name, health: &ServerHealth{}}
+			lag := time.Duration(0)
+			server.health.setUP(nil, true, false, &lag, &runningConnections, &runningConnections, nil)
+			return server
+		}
+
+		idle1 := newReplica("idle1", 1)
+		idle2 := newReplica("idle2", 2)
+		busy := newReplica("busy", 100)
+
+		Convey("It picks the better of two candidates", func() {
+			strategy := NewP2CStrategy(&Config{}, rand.NewSource(1))
+			for i := 0; i < 10; i++ {
+				So(strategy.Pick(Servers{busy, idle1}), ShouldPointTo, idle1)
+			}
+		})
+
+		Convey("It spreads the load and never picks the worst candidate", func() {
+			strategy := NewP2CStrategy(&Config{}, rand.NewSource(1))
+			picked := make(map[*Server]int)
+			for i := 0; i < 100; i++ {
+				picked[strategy.Pick(Servers{idle1, idle2, busy})]++
+			}
+			So(picked[busy], ShouldEqual, 0)
+			So(picked[idle1], ShouldBeGreaterThan, 0)
+			So(picked[idle2], ShouldBeGreaterThan, 0)
+		})
+
+		Convey("It is deterministic for a given random source", func() {
+			strategy1 := NewP2CStrategy(&Config{}, rand.NewSource(42))
+			strategy2 := NewP2CStrategy(&Config{}, rand.NewSource(42))
+			candidates := Servers{idle1, idle2, busy, newReplica("idle3", 2)}
+			for i := 0; i < 20; i++ {
+				So(strategy1.Pick(candidates), ShouldPointTo, strategy2.Pick(candidates))
+			}
+		})
+
+		Convey("It weighs the replication lag against the connections", func() {
+			lagged := newReplica("lagged", 0)
+			lag := time.Minute
+			lagged.health.replicationLag = &lag
+
+			strategy := NewP2CStrategy(&Config{}, rand.NewSource(1))
+			for i := 0; i < 10; i++ {
+				So(strategy.Pick(Servers{lagged, idle1}), ShouldPointTo, idle1)
+			}
+		})
+
+		Convey("It spreads the load over replicas with unequal lags", func() {
+			newLaggedReplica := func(name string, runningConnections int, lag time.Duration) *Server {
+				server := newReplica(name, runningConnections)
+				server.health.replicationLag = &lag
+				return server
+			}
+			a := newLaggedReplica("a", 500, 0)
+			b := newLaggedReplica("b", 0, time.Second)
+			c := newLaggedReplica("c", 0, time.Second)
+
+			strategy := NewP2CStrategy(&Config{MaxSecondsBehindMaster: 10}, rand.NewSource(1))
+			picked := make(map[*Server]int)
+			for i := 0; i < 100; i++ {
+				picked[strategy.Pick(Servers{a, b, c})]++
+			}
+			So(picked[a], ShouldEqual, 0)
+			So(picked[b], ShouldBeGreaterThan, 0)
+			So(picked[c], ShouldBeGreaterThan, 0)
+		})
+	})
+}
+
+func TestSmoothWeightedRoundRobinStrategy(t *testing.T) {
+	Convey("Given the smooth weighted round robin strategy", t, func() {
+		strategy := NewSmoothWeightedRoundRobinStrategy()
+		a := &Server{name: "a", weight: 5, health: &ServerHealth{}}
+		b := &Server{name: "b", weight: 1, health: &ServerHealth{}}
+		c := &Server{name: "c", weight: 1, health: &ServerHealth{}}
+		candidates := Servers{a, b, c}
+
+		Convey("It interleaves the candidates in proportion to their weight", func() {
+			var picked []string
+			for i := 0; i < 7; i++ {
+				picked = append(picked, strategy.Pick(candidates).name)
+			}
+			So(picked, ShouldResemble, []string{"a", "a", "b", "a", "c", "a", "a"})
+		})
+
+		Convey("It follows weight changes", func() {
+			b.setWeight(5)
+			c.setWeight(5)
+			picked := make(map[string]int)
+			for i := 0; i < 15; i++ {
+				picked[strategy.Pick(candidates).name]++
+			}
+			So(picked, ShouldResemble, map[string]int{"a": 5, "b": 5, "c": 5})
+		})
+	})
+}
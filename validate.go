@@ -0,0 +1,200 @@
+package balancer
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ConfigError is a problem with a setting of a Config
+type ConfigError struct {
+	// Field is the path of the setting, such as ServersSettings[1].DSN
+	Field string
+	Err   error
+}
+
+func (e *ConfigError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// ConfigErrors lists every problem found in a Config, see NewWithError
+type ConfigErrors []*ConfigError
+
+func (e ConfigErrors) Error() string {
+	messages := make([]string, len(e))
+	for i := range e {
+		messages[i] = e[i].Error()
+	}
+	return "balancer: invalid config: " + strings.Join(messages, "; ")
+}
+
+// Unwrap lets errors.Is and errors.As inspect every problem
+func (e ConfigErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i := range e {
+		errs[i] = e[i]
+	}
+	return errs
+}
+
+func (e *ConfigErrors) add(field string, format string, args ...interface{}) {
+	*e = append(*e, &ConfigError{Field: field, Err: fmt.Errorf(format, args...)})
+}
+
+// err returns e, or nil when there is no problem
+func (e ConfigErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// validate returns the ConfigErrors of the settings that would make servers
+// silently DOWN or break a running balancer
+func (c *Config) validate() error {
+	var errs ConfigErrors
+
+	if c.CheckInterval < 0 {
+		errs.add("CheckInterval", "negative interval %d", c.CheckInterval)
+	}
+	if c.ReplicationMode < 0 || int(c.ReplicationMode) >= len(replicationModeNames) {
+		errs.add("ReplicationMode", "unknown replication mode %d", c.ReplicationMode)
+	}
+	if c.LagFallback < 0 || int(c.LagFallback) >= len(lagFallbackPolicyNames) {
+		errs.add("LagFallback", "unknown lag fallback policy %d", c.LagFallback)
+	}
+
+	for _, setting := range []struct {
+		field string
+		value int
+	}{
+		{"MaxSecondsBehindMaster", c.MaxSecondsBehindMaster},
+		{"HealthCheckRise", c.HealthCheckRise},
+		{"HealthCheckFall", c.HealthCheckFall},
+		{"CircuitBreakerThreshold", c.CircuitBreakerThreshold},
+	} {
+		if setting.value < 0 {
+			errs.add(setting.field, "negative value %d", setting.value)
+		}
+	}
+	for _, setting := range []struct {
+		field string
+		value time.Duration
+	}{
+		{"StartupWait", c.StartupWait},
+		{"MaxLagTolerance", c.MaxLagTolerance},
+		{"HeartbeatInterval", c.HeartbeatInterval},
+		{"HealthCheckTimeout", c.HealthCheckTimeout},
+		{"CircuitBreakerCooldown", c.CircuitBreakerCooldown},
+		{"DrainTimeout", c.DrainTimeout},
+		{"ConsistencyWaitTimeout", c.ConsistencyWaitTimeout},
+	} {
+		if setting.value < 0 {
+			errs.add(setting.field, "negative duration %s", setting.value)
+		}
+	}
+	if c.ZoneSpillRatio < 0 {
+		errs.add("ZoneSpillRatio", "negative ratio %g", c.ZoneSpillRatio)
+	}
+	if c.HeartbeatInterval > 0 && c.HeartbeatTable == "" {
+		errs.add("HeartbeatInterval", "HeartbeatTable is required to write the heartbeat")
+	}
+
+	if len(c.ServersSettings) == 0 && len(c.Discoverers) == 0 && c.DNSDiscovery == nil {
+		errs.add("ServersSettings", "no servers nor discoverers")
+	}
+	errs = append(errs, validateServersSettings("ServersSettings", c.ServersSettings)...)
+
+	if c.DNSDiscovery != nil {
+		errs = append(errs, c.DNSDiscovery.validate("DNSDiscovery")...)
+	}
+
+	return errs.err()
+}
+
+// validateServersSettings returns the problems of a list of servers, located
+// under field
+func validateServersSettings(field string, serversSettings []ServerSettings) ConfigErrors {
+	var errs ConfigErrors
+
+	names := make(map[string]bool, len(serversSettings))
+	for i, serverSettings := range serversSettings {
+		serverField := fmt.Sprintf("%s[%d]", field, i)
+		errs = append(errs, validateServerSettings(serverField, serverSettings)...)
+
+		if serverSettings.Name != "" && names[serverSettings.Name] {
+			errs.add(serverField+".Name", "duplicated server %q", serverSettings.Name)
+		}
+		names[serverSettings.Name] = true
+	}
+
+	return errs
+}
+
+// validateServerSettings returns the problems of a server, located under field
+func validateServerSettings(field string, serverSettings ServerSettings) ConfigErrors {
+	var errs ConfigErrors
+
+	if serverSettings.Name == "" {
+		errs.add(field+".Name", "required")
+	}
+
+	if serverSettings.DSN == "" {
+		errs.add(field+".DSN", "required")
+	} else if err := checkDSN(serverSettings.DSN); err != nil {
+		errs.add(field+".DSN", "%s", err)
+	}
+
+	if serverSettings.Role < 0 || int(serverSettings.Role) >= len(serverRoleNames) {
+		errs.add(field+".Role", "unknown server role %d", serverSettings.Role)
+	}
+
+	// replicas' health is checked with the replication user
+	if serverSettings.ReplicationDSN == "" {
+		if serverSettings.Role == ServerRoleReplica {
+			errs.add(field+".ReplicationDSN", "required for replicas")
+		}
+	} else if err := checkDSN(serverSettings.ReplicationDSN); err != nil {
+		errs.add(field+".ReplicationDSN", "%s", err)
+	}
+
+	if serverSettings.Weight < 0 {
+		errs.add(field+".Weight", "negative weight %d", serverSettings.Weight)
+	}
+
+	return errs
+}
+
+// checkDSN checks the syntax of a go-sql-driver/mysql DSN:
+// [user[:password]@][net[(addr)]]/dbname[?param1=value1&paramN=valueN]
+func checkDSN(dsn string) error {
+	slash := strings.LastIndex(dsn, "/")
+	if slash < 0 {
+		return errors.New("unparsable DSN: missing the slash before the database name")
+	}
+
+	address := dsn[:slash]
+	if at := strings.LastIndex(address, "@"); at >= 0 {
+		address = address[at+1:]
+	}
+	if strings.Contains(address, "(") {
+		if !strings.HasSuffix(address, ")") {
+			return fmt.Errorf("unparsable DSN: unclosed address in %q", address)
+		}
+	} else if strings.Contains(address, ")") {
+		return fmt.Errorf("unparsable DSN: unopened address in %q", address)
+	}
+
+	if question := strings.Index(dsn[slash:], "?"); question >= 0 {
+		if _, err := url.ParseQuery(dsn[slash+question+1:]); err != nil {
+			return fmt.Errorf("unparsable DSN: %s", err)
+		}
+	}
+	return nil
+}
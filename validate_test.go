@@ -0,0 +1,129 @@
+package balancer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const (
+	testDSN            = "user@/db"
+	testReplicationDSN = "repl@/"
+)
+
+func TestNewWithError(t *testing.T) {
+	Convey("Given a valid config", t, func() {
+		config := &Config{
+			StartupWait: 100 * time.Millisecond,
+			ServersSettings: []ServerSettings{
+				{Name: "primary", DSN: "user:pass@tcp(primary:3306)/db?timeout=1s", Role: ServerRolePrimary},
+				{Name: "replica", DSN: "user:pass@tcp([::1]:3306)/db", ReplicationDSN: "repl:pass@unix(/tmp/mysql.sock)/"},
+			},
+		}
+
+		Convey("It should return a balancer", func() {
+			balancer, err := NewWithError(config)
+			So(err, ShouldBeNil)
+			So(balancer, ShouldNotBeNil)
+			So(balancer.GetServers(), ShouldHaveLength, 2)
+			balancer.Close()
+		})
+
+		Convey("It should not modify the config", func() {
+			balancer, _ := NewWithError(config)
+			So(config.CheckInterval, ShouldEqual, 0)
+			So(config.HealthCheckTimeout, ShouldEqual, 0)
+			So(config.CircuitBreakerCooldown, ShouldEqual, 0)
+			So(balancer.config.CheckInterval, ShouldEqual, defaultCheckInterval)
+			balancer.Close()
+		})
+	})
+
+	Convey("Given an invalid config", t, func() {
+		config := &Config{
+			ReplicationMode: ReplicationMode(7),
+			LagFallback:     LagFallbackPolicy(-1),
+			HealthCheckFall: -1,
+			DrainTimeout:    -time.Second,
+			ServersSettings: []ServerSettings{
+				{Name: "replica1", DSN: "user:pass@tcp(replica1:3306/db", ReplicationDSN: testReplicationDSN},
+				{Name: "replica1", DSN: testDSN},
+				{DSN: "replica3", ReplicationDSN: "repl@/?%zz", Weight: -1, Role: ServerRole(2)},
+			},
+			DNSDiscovery: &DNSDiscovery{Port: 70000, Template: ServerSettings{DSN: "user@tcp({addr})/db"}},
+		}
+
+		Convey("It should list every problem", func() {
+			balancer, err := NewWithError(config)
+			So(balancer, ShouldBeNil)
+
+			var errs ConfigErrors
+			So(errors.As(err, &errs), ShouldBeTrue)
+
+			fields := make([]string, len(errs))
+			for i := range errs {
+				fields[i] = errs[i].Field
+			}
+			So(fields, ShouldResemble, []string{
+				"ReplicationMode",
+				"LagFallback",
+				"HealthCheckFall",
+				"DrainTimeout",
+				"ServersSettings[0].DSN",
+				"ServersSettings[1].ReplicationDSN",
+				"ServersSettings[1].Name",
+				"ServersSettings[2].Name",
+				"ServersSettings[2].DSN",
+				"ServersSettings[2].Role",
+				"ServersSettings[2].ReplicationDSN",
+				"ServersSettings[2].Weight",
+				"DNSDiscovery.Name",
+				"DNSDiscovery.Port",
+				"DNSDiscovery.Template.ReplicationDSN",
+			})
+			So(err.Error(), ShouldContainSubstring, `ServersSettings[1].Name: duplicated server "replica1"`)
+		})
+
+		Convey("It should require servers or discoverers", func() {
+			_, err := NewWithError(&Config{})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "ServersSettings: no servers nor discoverers")
+
+			_, err = NewWithError(&Config{HeartbeatInterval: time.Second, Discoverers: []Discoverer{newChanDiscoverer()}})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldNotContainSubstring, "no servers")
+			So(err.Error(), ShouldContainSubstring, "HeartbeatInterval")
+		})
+	})
+}
+
+func TestCheckDSN(t *testing.T) {
+	Convey("Given DSNs", t, func() {
+		Convey("It should accept valid ones", func() {
+			for _, dsn := range []string{
+				"/",
+				"user@/db",
+				"user:pass@tcp(127.0.0.1:3306)/db",
+				"user:p@ss/word@tcp(host)/db?parseTime=true&loc=UTC",
+				"user@unix(/var/run/mysqld/mysqld.sock)/db",
+				"user@tcp([::1]:3306)/",
+			} {
+				So(checkDSN(dsn), ShouldBeNil)
+			}
+		})
+
+		Convey("It should reject invalid ones", func() {
+			for _, dsn := range []string{
+				"",
+				"replica1",
+				"user@tcp(host:3306/db",
+				"user@tcphost:3306)/db",
+				"user@/db?timeout=%zz",
+			} {
+				So(checkDSN(dsn), ShouldNotBeNil)
+			}
+		})
+	})
+}
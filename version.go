@@ -0,0 +1,66 @@
+package balancer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// serverVersion is a MySQL or MariaDB server version
+type serverVersion struct {
+	major, minor, patch int
+	mariaDB             bool
+}
+
+// parseServerVersion parses the result of SELECT VERSION(), such as
+// "8.0.23-log" or "10.5.8-MariaDB-1:10.5.8+maria~focal"
+func parseServerVersion(raw string) (serverVersion, error) {
+	version := serverVersion{
+		mariaDB: strings.Contains(strings.ToLower(raw), "mariadb"),
+	}
+
+	number := raw
+	if end := strings.IndexFunc(raw, func(r rune) bool {
+		return r != '.' && (r < '0' || r > '9')
+	}); end >= 0 {
+		number = raw[:end]
+	}
+
+	parts := strings.SplitN(number, ".", 3)
+	if len(parts) < 2 {
+		return version, fmt.Errorf("unexpected MySQL version %q", raw)
+	}
+
+	numbers := []*int{&version.major, &version.minor, &version.patch}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return version, fmt.Errorf("unexpected MySQL version %q", raw)
+		}
+		*numbers[i] = n
+	}
+
+	return version, nil
+}
+
+func (v serverVersion) atLeast(major, minor, patch int) bool {
+	if v.major != major {
+		return v.major > major
+	}
+	if v.minor != minor {
+		return v.minor > minor
+	}
+	return v.patch >= patch
+}
+
+// hasReplicaTerminology tells whether SHOW REPLICA STATUS and the
+// Source/Replica column names are available (MySQL 8.0.22+)
+func (v serverVersion) hasReplicaTerminology() bool {
+	return !v.mariaDB && v.atLeast(8, 0, 22)
+}
+
+// hasSlaveRunningStatus tells whether the Slave_running status variable is
+// available (removed in MySQL 8.0)
+func (v serverVersion) hasSlaveRunningStatus() bool {
+	return v.mariaDB || !v.atLeast(8, 0, 0)
+}
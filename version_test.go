@@ -0,0 +1,46 @@
+package balancer
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseServerVersion(t *testing.T) {
+	Convey("When MySQL versions are given", t, func() {
+		Convey("It parses MySQL versions", func() {
+			version, err := parseServerVersion("8.0.23-log")
+			So(err, ShouldBeNil)
+			So(version, ShouldResemble, serverVersion{major: 8, minor: 0, patch: 23})
+			So(version.hasReplicaTerminology(), ShouldBeTrue)
+			So(version.hasSlaveRunningStatus(), ShouldBeFalse)
+
+			version, err = parseServerVersion("8.0.21")
+			So(err, ShouldBeNil)
+			So(version.hasReplicaTerminology(), ShouldBeFalse)
+			So(version.hasSlaveRunningStatus(), ShouldBeFalse)
+
+			version, err = parseServerVersion("5.7.30-33-log")
+			So(err, ShouldBeNil)
+			So(version, ShouldResemble, serverVersion{major: 5, minor: 7, patch: 30})
+			So(version.hasReplicaTerminology(), ShouldBeFalse)
+			So(version.hasSlaveRunningStatus(), ShouldBeTrue)
+		})
+
+		Convey("It parses MariaDB versions", func() {
+			version, err := parseServerVersion("10.5.8-MariaDB-1:10.5.8+maria~focal")
+			So(err, ShouldBeNil)
+			So(version, ShouldResemble, serverVersion{major: 10, minor: 5, patch: 8, mariaDB: true})
+			So(version.hasReplicaTerminology(), ShouldBeFalse)
+			So(version.hasSlaveRunningStatus(), ShouldBeTrue)
+		})
+
+		Convey("It fails on invalid versions", func() {
+			_, err := parseServerVersion("unknown")
+			So(err, ShouldNotBeNil)
+
+			_, err = parseServerVersion("")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}